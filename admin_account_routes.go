@@ -0,0 +1,248 @@
+package main
+
+import (
+	"emmApi/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrAdminNotFound = fiber.Map{"error": "Admin account not found."}
+var ErrAdminKeyNotFound = fiber.Map{"error": "Admin API key not found."}
+var ErrAdminNameRequired = fiber.Map{"error": "Admin name is required."}
+var ErrAdminNameTaken = fiber.Map{"error": "Admin name is already taken."}
+var ErrInvalidAdminRole = fiber.Map{"error": "Admin role must be one of viewer, moderator or superadmin."}
+
+func adminAccountRoutes(router fiber.Router) {
+	router.Get("/admin/me", RequireAdmin(models.RoleViewer), GetCurrentAdmin)
+
+	router.Get("/admin/accounts", RequireAdmin(models.RoleSuperAdmin), ListAdminAccounts)
+	router.Post("/admin/accounts", RequireAdmin(models.RoleSuperAdmin), CreateAdminAccount)
+	router.Patch("/admin/accounts/:admin_id", RequireAdmin(models.RoleSuperAdmin), UpdateAdminAccount)
+
+	router.Get("/admin/accounts/:admin_id/keys", RequireAdmin(models.RoleSuperAdmin), ListAdminApiKeys)
+	router.Post("/admin/accounts/:admin_id/keys", RequireAdmin(models.RoleSuperAdmin), CreateAdminApiKey)
+	router.Delete("/admin/accounts/:admin_id/keys/:key_id", RequireAdmin(models.RoleSuperAdmin), RevokeAdminApiKey)
+}
+
+func GetCurrentAdmin(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"admin_id":   c.Locals("adminId"),
+		"admin_name": c.Locals("adminName"),
+		"admin_role": c.Locals("adminRole"),
+	})
+}
+
+func ListAdminAccounts(c *fiber.Ctx) error {
+	var admins []models.AdminPrincipal
+
+	tx := DatabaseConnection.Order("admin_name ASC").Find(&admins)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(admins)
+}
+
+func CreateAdminAccount(c *fiber.Ctx) error {
+	var r AdminAccountRequest
+	var existing models.AdminPrincipal
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if r.AdminName == "" {
+		return c.Status(http.StatusBadRequest).JSON(ErrAdminNameRequired)
+	}
+
+	if !r.AdminRole.IsValid() {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidAdminRole)
+	}
+
+	tx := DatabaseConnection.Where("admin_name = ?", r.AdminName).First(&existing)
+
+	if tx.Error == nil {
+		return c.Status(http.StatusConflict).JSON(ErrAdminNameTaken)
+	} else if tx.Error != gorm.ErrRecordNotFound {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	adminId, err := GenerateId("adm_")
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	key, hash, err := GenerateAdminApiKey()
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	a := models.AdminPrincipal{
+		AdminId:   adminId,
+		AdminName: r.AdminName,
+		AdminRole: r.AdminRole,
+	}
+
+	k := models.AdminApiKey{
+		AdminId:   a.AdminId,
+		KeyHash:   hash,
+		KeyPrefix: key[:14],
+		KeyLabel:  "initial",
+	}
+
+	// the account comes with its first key, otherwise the bootstrap secret would have nothing to hand over to
+	err = DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&k).Error
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "admin.create", "admin", a.AdminId, nil, a)
+	RecordAudit(c, "admin.key_create", "admin", a.AdminId, nil, k)
+
+	return c.Status(http.StatusCreated).JSON(AdminAccountResponse{
+		AdminPrincipal: a,
+		Key:            key,
+	})
+}
+
+func UpdateAdminAccount(c *fiber.Ctx) error {
+	var r AdminAccountUpdateRequest
+	var a models.AdminPrincipal
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	tx := DatabaseConnection.Where("admin_id = ?", c.Params("admin_id")).First(&a)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrAdminNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	before := a
+
+	if r.AdminRole != nil {
+		if !r.AdminRole.IsValid() {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidAdminRole)
+		}
+
+		a.AdminRole = *r.AdminRole
+	}
+
+	if r.IsDisabled != nil {
+		a.IsDisabled = *r.IsDisabled
+	}
+
+	tx = DatabaseConnection.Save(&a)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "admin.update", "admin", a.AdminId, before, a)
+
+	return c.Status(http.StatusOK).JSON(a)
+}
+
+func ListAdminApiKeys(c *fiber.Ctx) error {
+	var keys []models.AdminApiKey
+
+	tx := DatabaseConnection.Where("admin_id = ?", c.Params("admin_id")).Order("id DESC").Find(&keys)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(keys)
+}
+
+func CreateAdminApiKey(c *fiber.Ctx) error {
+	var r AdminApiKeyRequest
+	var a models.AdminPrincipal
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	tx := DatabaseConnection.Where("admin_id = ?", c.Params("admin_id")).First(&a)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrAdminNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	key, hash, err := GenerateAdminApiKey()
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	k := models.AdminApiKey{
+		AdminId:   a.AdminId,
+		KeyHash:   hash,
+		KeyPrefix: key[:14],
+		KeyLabel:  r.KeyLabel,
+	}
+
+	tx = DatabaseConnection.Create(&k)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "admin.key_create", "admin", a.AdminId, nil, k)
+
+	return c.Status(http.StatusCreated).JSON(AdminApiKeyResponse{
+		AdminApiKey: k,
+		Key:         key,
+	})
+}
+
+func RevokeAdminApiKey(c *fiber.Ctx) error {
+	var k models.AdminApiKey
+
+	keyId, err := strconv.Atoi(c.Params("key_id"))
+
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	tx := DatabaseConnection.Where("id = ? AND admin_id = ?", keyId, c.Params("admin_id")).First(&k)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrAdminKeyNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+
+		tx = DatabaseConnection.Save(&k)
+
+		if tx.Error != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		RecordAudit(c, "admin.key_revoke", "admin", k.AdminId, nil, k)
+	}
+
+	return c.Status(http.StatusOK).JSON(k)
+}
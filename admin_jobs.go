@@ -0,0 +1,279 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+const (
+	JobRebuildSearchIndex = "search_index.rebuild"
+	JobUnindexAuthor      = "search_index.unindex_author"
+	JobReindexAuthor      = "search_index.reindex_author"
+	JobTransferFavorites  = "favorites.transfer"
+	JobReconcileSearch    = "search_index.reconcile"
+)
+
+func RegisterAdminJobs() {
+	RegisterJobType(JobRebuildSearchIndex, RebuildSearchIndexJob)
+	RegisterJobType(JobUnindexAuthor, UnindexAuthorJob)
+	RegisterJobType(JobReindexAuthor, ReindexAuthorJob)
+	RegisterJobType(JobTransferFavorites, TransferFavoritesJob)
+	RegisterJobType(JobReconcileSearch, ReconcileSearchIndexJob)
+}
+
+// RebuildSearchIndexJob builds a fresh versioned index and suggestion dictionaries while searches
+// keep hitting the live ones through the alias, then switches over once every avatar is in
+func RebuildSearchIndexJob(j *JobContext) error {
+	var a []models.Avatar
+	var b []models.BlacklistedAuthor
+	var total, done int64
+
+	// a previous attempt that died part way leaves its half built index behind
+	if _, building := GetSearchVersions(); building != "" {
+		RedisConnection.Del(ctx, buildingSearchVersionKey)
+		go DropSearchIndex(building)
+	}
+
+	current, _ := GetSearchVersions()
+
+	// the legacy index keeps serving searches until the switch, as long as it ignores the new documents
+	if current == "" {
+		if err := FilterLegacySearchIndex(); err != nil {
+			return err
+		}
+	}
+
+	version := strconv.FormatInt(time.Now().Unix(), 10)
+
+	if err := CreateSearchIndex(version); err != nil {
+		return err
+	}
+
+	RedisConnection.Set(ctx, buildingSearchVersionKey, version, 0)
+
+	abandon := func(err error) error {
+		RedisConnection.Del(ctx, buildingSearchVersionKey)
+		go DropSearchIndex(version)
+		return err
+	}
+
+	if err := DatabaseConnection.Find(&b).Error; err != nil {
+		return abandon(err)
+	}
+
+	blacklisted := make(map[string]bool, len(b))
+
+	for _, author := range b {
+		blacklisted[author.UserId] = true
+	}
+
+	query := DatabaseConnection.Model(&models.Avatar{}).Where("avatar_public = ? AND is_shadowed IS NOT TRUE", "t")
+
+	if err := query.Count(&total).Error; err != nil {
+		return abandon(err)
+	}
+
+	tx := query.FindInBatches(&a, 1000, func(tx *gorm.DB, batch int) error {
+		for _, avatar := range a {
+			if blacklisted[avatar.AvatarAuthorId] {
+				continue
+			}
+
+			l := avatar.GetLimitedAvatar()
+			res, err := ReJsonClient.JSONSet(SearchDocumentKey(version, l.AvatarId), "$", l)
+
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			if res.(string) != "OK" {
+				fmt.Printf("Error adding avatar to search index: %s\n", l.AvatarId)
+			}
+		}
+
+		done += int64(len(a))
+		return j.Progress(done, total)
+	})
+
+	if tx.Error != nil {
+		return abandon(tx.Error)
+	}
+
+	if err := FillSuggestionsTo(version); err != nil {
+		return abandon(err)
+	}
+
+	if err := SwitchSearchIndex(version); err != nil {
+		return abandon(err)
+	}
+
+	return nil
+}
+
+func UnindexAuthorJob(j *JobContext) error {
+	var r GenericUserRequest
+
+	if err := j.DecodePayload(&r); err != nil {
+		return err
+	}
+
+	defer InvalidateSearchCache()
+
+	return ForEachAuthorAvatar(j, r.UserId, func(avatar *models.Avatar) error {
+		if err := DeleteSearchDocument(avatar.AvatarIdSha256); err != nil {
+			return err
+		}
+
+		if !avatar.AvatarPublic || avatar.IsShadowed {
+			return nil
+		}
+
+		return RemoveSuggestions(avatar)
+	})
+}
+
+func ReindexAuthorJob(j *JobContext) error {
+	var r GenericUserRequest
+
+	if err := j.DecodePayload(&r); err != nil {
+		return err
+	}
+
+	defer InvalidateSearchCache()
+
+	return ForEachAuthorAvatar(j, r.UserId, func(avatar *models.Avatar) error {
+		if !avatar.AvatarPublic || avatar.IsShadowed {
+			return nil
+		}
+
+		if err := SetSearchDocument(avatar.GetLimitedAvatar()); err != nil {
+			return err
+		}
+
+		return AddSuggestions(avatar)
+	})
+}
+
+func ForEachAuthorAvatar(j *JobContext, authorId string, fn func(avatar *models.Avatar) error) error {
+	var a []models.Avatar
+	var total, done int64
+
+	query := DatabaseConnection.Model(&models.Avatar{}).Where("avatar_author_id = ?", authorId)
+
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+
+	tx := query.FindInBatches(&a, 1000, func(tx *gorm.DB, batch int) error {
+		for i := range a {
+			if err := fn(&a[i]); err != nil {
+				return err
+			}
+		}
+
+		done += int64(len(a))
+		return j.Progress(done, total)
+	})
+
+	return tx.Error
+}
+
+// TransferFavoritesJob moves favorites across in batches so a cancel or restart leaves
+// every favorite with exactly one of the two users. Avatars the target already favorited are
+// dropped from the source instead, so each avatar's favorite count stays one per user.
+func TransferFavoritesJob(j *JobContext) error {
+	var t TransferRequest
+	var favorites []models.AvatarFavorite
+	var total, done int64
+
+	if err := j.DecodePayload(&t); err != nil {
+		return err
+	}
+
+	query := DatabaseConnection.Model(&models.AvatarFavorite{}).Where("user_id = ?", t.UserId)
+
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+
+	tx := query.FindInBatches(&favorites, 500, func(tx *gorm.DB, batch int) error {
+		var held []string
+
+		avatarIds := make([]string, len(favorites))
+
+		for i, f := range favorites {
+			avatarIds[i] = f.AvatarId
+		}
+
+		err := DatabaseConnection.Model(&models.AvatarFavorite{}).
+			Where("user_id = ? AND avatar_id IN ?", t.TargetUserId, avatarIds).
+			Pluck("avatar_id", &held).Error
+
+		if err != nil {
+			return err
+		}
+
+		alreadyHeld := make(map[string]bool, len(held))
+
+		for _, avatarId := range held {
+			alreadyHeld[avatarId] = true
+		}
+
+		// the source rows are soft deleted and the target gets new ones, so the delta sync of
+		// both users sees the change, the source as removals and the target as additions
+		transferred := make([]uint, len(favorites))
+		moved := make([]models.AvatarFavorite, 0, len(favorites))
+		droppedAvatars := make([]string, 0)
+
+		for i, f := range favorites {
+			transferred[i] = f.ID
+
+			if alreadyHeld[f.AvatarId] {
+				droppedAvatars = append(droppedAvatars, f.AvatarId)
+			} else {
+				moved = append(moved, models.AvatarFavorite{
+					UserId:    t.TargetUserId,
+					AvatarId:  f.AvatarId,
+					Position:  f.Position,
+					Note:      f.Note,
+					Tags:      f.Tags,
+					CreatedAt: f.CreatedAt,
+				})
+			}
+		}
+
+		err = DatabaseConnection.Transaction(func(db *gorm.DB) error {
+			if err := db.Where("id IN ?", transferred).Delete(&models.AvatarFavorite{}).Error; err != nil {
+				return err
+			}
+
+			// the lists belong to the source user, the target starts with the favorites unlisted
+			if err := db.Where("favorite_id IN ?", transferred).Delete(&models.FavoriteListEntry{}).Error; err != nil {
+				return err
+			}
+
+			if len(moved) > 0 {
+				if err := db.Create(&moved).Error; err != nil {
+					return err
+				}
+			}
+
+			return AdjustFavoriteCounts(db, droppedAvatars, -1)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		SyncSearchFavoriteCounts(droppedAvatars)
+
+		done += int64(len(favorites))
+		return j.Progress(done, total)
+	})
+
+	return tx.Error
+}
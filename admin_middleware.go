@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"emmApi/models"
+	"encoding/hex"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BootstrapAdminId is the principal reported for requests authenticated with ServiceConfig.AdminSecret
+const BootstrapAdminId = "bootstrap"
+
+var ErrInvalidAdminKey = fiber.Map{"error": "Invalid admin API key."}
+var ErrInsufficientRole = fiber.Map{"error": "Your admin role does not allow this action."}
+
+// RequireAdmin authenticates an admin API key and checks its principal holds at least the given role.
+// The legacy AdminSecret is only accepted, as a superadmin, until an enabled superadmin has a usable key.
+func RequireAdmin(role models.AdminRole) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		authorizationHeader := c.Get("Authorization")
+
+		if !strings.HasPrefix(authorizationHeader, "Bearer ") {
+			return c.Status(http.StatusUnauthorized).
+				JSON(ErrMissingBearerToken)
+		}
+
+		authorizationHeader = strings.TrimPrefix(authorizationHeader, "Bearer ")
+
+		if ServiceConfig.AdminSecret != "" &&
+			subtle.ConstantTimeCompare([]byte(authorizationHeader), []byte(ServiceConfig.AdminSecret)) == 1 {
+			var keys []models.AdminApiKey
+
+			if err := DatabaseConnection.Preload("Admin").Where("revoked_at IS NULL").Find(&keys).Error; err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+			}
+
+			if !CanBootstrapAdmin(keys) {
+				fmt.Printf("Rejected admin secret for %s %s from %s, a superadmin key already exists\n", c.Method(), c.Path(), c.IP())
+				return c.Status(http.StatusUnauthorized).JSON(ErrInvalidAdminKey)
+			}
+
+			fmt.Printf("Admin secret used to bootstrap %s %s from %s\n", c.Method(), c.Path(), c.IP())
+
+			c.Locals("adminId", BootstrapAdminId)
+			c.Locals("adminName", BootstrapAdminId)
+			c.Locals("adminRole", models.RoleSuperAdmin)
+
+			return c.Next()
+		}
+
+		var k models.AdminApiKey
+
+		tx := DatabaseConnection.Preload("Admin").
+			Where("key_hash = ? AND revoked_at IS NULL", HashAdminApiKey(authorizationHeader)).
+			First(&k)
+
+		if tx.Error != nil || k.Admin == nil || k.Admin.IsDisabled {
+			return c.Status(http.StatusUnauthorized).JSON(ErrInvalidAdminKey)
+		}
+
+		if k.Admin.AdminRole.Level() < role.Level() {
+			return c.Status(http.StatusForbidden).JSON(ErrInsufficientRole)
+		}
+
+		DatabaseConnection.Model(&k).UpdateColumn("last_used", time.Now())
+
+		c.Locals("adminId", k.Admin.AdminId)
+		c.Locals("adminName", k.Admin.AdminName)
+		c.Locals("adminRole", k.Admin.AdminRole)
+
+		return c.Next()
+	}
+}
+
+// CanBootstrapAdmin tells whether the admin secret is still needed, which is the case as long as
+// none of the keys lets an enabled superadmin in
+func CanBootstrapAdmin(keys []models.AdminApiKey) bool {
+	for _, k := range keys {
+		if k.RevokedAt == nil && k.Admin != nil && !k.Admin.IsDisabled && k.Admin.AdminRole == models.RoleSuperAdmin {
+			return false
+		}
+	}
+
+	return true
+}
+
+func GetAdminName(c *fiber.Ctx) string {
+	name, _ := c.Locals("adminName").(string)
+	return name
+}
+
+// GenerateAdminApiKey returns the plaintext key, which is only ever shown once, and its stored hash
+func GenerateAdminApiKey() (string, string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key := "emmadm_" + hex.EncodeToString(b)
+	return key, HashAdminApiKey(key), nil
+}
+
+// HashAdminApiKey keys are 256 bits of randomness so a fast hash is enough and keeps lookups cheap
+func HashAdminApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
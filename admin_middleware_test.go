@@ -0,0 +1,38 @@
+package main
+
+import (
+	"emmApi/models"
+	"testing"
+	"time"
+)
+
+// TestCanBootstrapAdmin walks through setting up the first admin with the secret, which has to
+// keep working until the superadmin it created can sign in with their own key
+func TestCanBootstrapAdmin(t *testing.T) {
+	revoked := time.Now()
+
+	viewer := &models.AdminPrincipal{AdminId: "adm_viewer", AdminRole: models.RoleViewer}
+	root := &models.AdminPrincipal{AdminId: "adm_root", AdminRole: models.RoleSuperAdmin}
+	disabled := &models.AdminPrincipal{AdminId: "adm_disabled", AdminRole: models.RoleSuperAdmin, IsDisabled: true}
+
+	tests := []struct {
+		name string
+		keys []models.AdminApiKey
+		want bool
+	}{
+		{"no admins yet", nil, true},
+		{"only a viewer was created", []models.AdminApiKey{{Admin: viewer}}, true},
+		{"superadmin created with its initial key", []models.AdminApiKey{{Admin: viewer}, {Admin: root}}, false},
+		{"superadmin key revoked", []models.AdminApiKey{{Admin: root, RevokedAt: &revoked}}, true},
+		{"superadmin disabled", []models.AdminApiKey{{Admin: disabled}}, true},
+		{"key without its admin", []models.AdminApiKey{{AdminId: "adm_gone"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanBootstrapAdmin(tt.keys); got != tt.want {
+				t.Errorf("CanBootstrapAdmin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,59 +3,48 @@ package main
 import (
 	"context"
 	"emmApi/models"
-	"fmt"
-	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 )
 
 var ctx = context.Background()
 
 var ErrAlreadyRebuilding = fiber.Map{"error": "Search index rebuild is already running. Please wait."}
+var ErrAlreadyReconciling = fiber.Map{"error": "Search index check is already running. Please wait."}
 
 var ErrUserNotFound = fiber.Map{"error": "User not found."}
 var ErrUserAlreadyBlacklisted = fiber.Map{"error": "User is already blacklisted."}
 var ErrUserIsNotBlacklisted = fiber.Map{"error": "User is not blacklisted."}
+var ErrUserNotDeleted = fiber.Map{"error": "User is not deleted or has already been purged."}
+var ErrNoDeletedFavorites = fiber.Map{"error": "No deleted favorites found at that time."}
 
 func adminRoutes(router fiber.Router) {
-	router.Post("/admin/rebuild_search_index", EnforceAdminSecret, RebuildSearchIndex)
-	router.Get("/admin/rebuild_search_index/status", EnforceAdminSecret, RebuildSearchIndexStatus)
-
-	router.Post("/admin/reset_user_pin", EnforceAdminSecret, ResetUserPin)
-	router.Post("/admin/export_user_favorites", EnforceAdminSecret, ExportFavoritesAdmin)
-	router.Post("/admin/wipe_user_favorites", EnforceAdminSecret, WipeUserFavorites)
-	router.Post("/admin/transfer_user_favorites", EnforceAdminSecret, TransferUserFavorites)
-	router.Delete("/admin/delete_user", EnforceAdminSecret, DeleteUser)
-
-	router.Get("/admin/avatar/:avatar_id", EnforceAdminSecret, GetAdminAvatar)
-
-	router.Post("/admin/blacklist_avatar/:avatar_id", EnforceAdminSecret, BlacklistAvatar)
-	router.Post("/admin/blacklist_author", EnforceAdminSecret, BlacklistAvatarAuthor)
-	router.Delete("/admin/blacklist_author", EnforceAdminSecret, UnBlacklistAvatarAuthor)
-
-	router.Get("/admin/online_user_count", EnforceAdminSecret, GetOnlineUserCount)
-}
-
-func EnforceAdminSecret(c *fiber.Ctx) error {
-	authorizationHeader := c.Get("Authorization")
-
-	if !strings.HasPrefix(authorizationHeader, "Bearer ") {
-		return c.Status(http.StatusUnauthorized).
-			JSON(ErrMissingBearerToken)
-	}
-
-	authorizationHeader = strings.TrimPrefix(authorizationHeader, "Bearer ")
-
-	if authorizationHeader != ServiceConfig.AdminSecret {
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{})
-	}
-
-	return c.Next()
+	router.Post("/admin/rebuild_search_index", RequireAdmin(models.RoleSuperAdmin), RebuildSearchIndex)
+	router.Get("/admin/rebuild_search_index/status", RequireAdmin(models.RoleViewer), RebuildSearchIndexStatus)
+	router.Post("/admin/reconcile_search_index", RequireAdmin(models.RoleSuperAdmin), ReconcileSearchIndex)
+	router.Get("/admin/search_cache", RequireAdmin(models.RoleViewer), GetSearchCacheStatus)
+
+	router.Post("/admin/reset_user_pin", RequireAdmin(models.RoleModerator), ResetUserPin)
+	router.Post("/admin/set_favorite_limit", RequireAdmin(models.RoleModerator), SetUserFavoriteLimit)
+	router.Post("/admin/export_user_favorites", RequireAdmin(models.RoleModerator), ExportFavoritesAdmin)
+	router.Post("/admin/wipe_user_favorites", RequireAdmin(models.RoleModerator), WipeUserFavorites)
+	router.Post("/admin/transfer_user_favorites", RequireAdmin(models.RoleSuperAdmin), TransferUserFavorites)
+	router.Delete("/admin/delete_user", RequireAdmin(models.RoleSuperAdmin), DeleteUser)
+
+	router.Get("/admin/deleted_users", RequireAdmin(models.RoleModerator), ListDeletedUsers)
+	router.Post("/admin/restore_user", RequireAdmin(models.RoleModerator), RestoreDeletedUser)
+	router.Get("/admin/deleted_user_favorites", RequireAdmin(models.RoleModerator), ListDeletedUserFavorites)
+	router.Post("/admin/restore_user_favorites", RequireAdmin(models.RoleModerator), RestoreUserFavorites)
+
+	router.Get("/admin/avatar/:avatar_id", RequireAdmin(models.RoleViewer), GetAdminAvatar)
+
+	router.Post("/admin/blacklist_avatar/:avatar_id", RequireAdmin(models.RoleModerator), BlacklistAvatar)
+	router.Post("/admin/blacklist_author", RequireAdmin(models.RoleModerator), BlacklistAvatarAuthor)
+	router.Delete("/admin/blacklist_author", RequireAdmin(models.RoleModerator), UnBlacklistAvatarAuthor)
+
+	router.Get("/admin/online_user_count", RequireAdmin(models.RoleViewer), GetOnlineUserCount)
 }
 
 func GetOnlineUserCount(c *fiber.Ctx) error {
@@ -86,59 +75,178 @@ func DeleteUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(ErrUserNotFound)
 	}
 
-	tx = DatabaseConnection.Where("user_id = ?", r.UserId).Delete(&models.AvatarFavorite{})
+	before := fiber.Map{"user": AuditUserSnapshot(&u), "favorites": AuditFavoritesSnapshot(r.UserId)}
 
-	if tx.Error != nil {
+	if err := SoftDeleteUser(r.UserId); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	tx = DatabaseConnection.Where("user_id = ?", r.UserId).Delete(&models.PersistentToken{})
+	RecordAudit(c, "user.delete", "user", r.UserId, before, nil)
 
-	if tx.Error != nil {
+	return c.Status(http.StatusNoContent).JSON(fiber.Map{})
+}
+
+func ListDeletedUsers(c *fiber.Ctx) error {
+	var q PageQuery
+	var users []models.User
+	var total int64
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	q.Page, q.Limit = ClampPagination(q.Page, q.Limit)
+	retention := GetDeletedRetention()
+	tx := DatabaseConnection.Unscoped().Model(&models.User{}).Where("deleted_at > ?", time.Now().Add(-retention))
+
+	if err := tx.Count(&total).Error; err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	tx = DatabaseConnection.Delete(&u)
+	err := tx.Order("deleted_at DESC").Offset((q.Page - 1) * q.Limit).Limit(q.Limit).Find(&users).Error
 
-	if tx.Error != nil {
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	return c.Status(http.StatusNoContent).JSON(fiber.Map{})
+	res := DeletedUserListResponse{
+		Users: make([]DeletedUserResponse, len(users)),
+		Page:  q.Page,
+		Limit: q.Limit,
+		Total: total,
+	}
+
+	for i, u := range users {
+		var favorites int64
+
+		DatabaseConnection.Unscoped().Model(&models.AvatarFavorite{}).
+			Where("user_id = ? AND deleted_at = ?", u.UserId, u.DeletedAt.Time).
+			Count(&favorites)
+
+		res.Users[i] = DeletedUserResponse{
+			UserId:           u.UserId,
+			UserKnownAliases: u.UserKnownAliases,
+			DeletedAt:        u.DeletedAt.Time,
+			PurgeAt:          u.DeletedAt.Time.Add(retention),
+			FavoriteCount:    favorites,
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(res)
 }
 
-func ExportFavoritesAdmin(c *fiber.Ctx) error {
+func RestoreDeletedUser(c *fiber.Ctx) error {
 	var r GenericUserRequest
-	var favorites []models.AvatarFavorite
-	var avatars []models.Avatar
-	var export []AvatarExportResponse
+	var u models.User
 
 	if err := c.BodyParser(&r); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
 	}
 
-	tx := DatabaseConnection.Preload(clause.Associations).Where("user_id = ?", r.UserId).Order("id DESC").Find(&favorites)
+	tx := DatabaseConnection.Unscoped().Where("user_id = ?", r.UserId).First(&u)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrUserNotDeleted)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if !u.DeletedAt.Valid {
+		return c.Status(http.StatusBadRequest).JSON(ErrUserNotDeleted)
+	}
+
+	if err := RestoreUser(&u); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "user.restore", "user", r.UserId, nil, fiber.Map{"user": AuditUserSnapshot(&u), "favorites": AuditFavoritesSnapshot(r.UserId)})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{})
+}
+
+// ListDeletedUserFavorites groups a user's favorite tombstones by the time they were removed
+func ListDeletedUserFavorites(c *fiber.Ctx) error {
+	var groups []DeletedFavoriteGroup
+
+	userId := c.Query("user_id")
+
+	tx := DatabaseConnection.Unscoped().Model(&models.AvatarFavorite{}).
+		Select("deleted_at, COUNT(*) AS favorite_count").
+		Where("user_id = ? AND deleted_at > ?", userId, time.Now().Add(-GetDeletedRetention())).
+		Group("deleted_at").
+		Order("deleted_at DESC").
+		Scan(&groups)
 
 	if tx.Error != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	avatars = make([]models.Avatar, len(favorites))
+	return c.Status(http.StatusOK).JSON(groups)
+}
+
+// RestoreUserFavorites undoes a wipe by bringing back the favorites removed at deleted_at,
+// skipping any avatar the user has favorited again since
+func RestoreUserFavorites(c *fiber.Ctx) error {
+	var r RestoreFavoritesRequest
 
-	for i := 0; i < len(favorites); i++ {
-		avatars[i] = *favorites[i].Avatar
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
 	}
 
-	export = make([]AvatarExportResponse, len(avatars))
+	var avatarIds []string
 
-	for i := 0; i < len(avatars); i++ {
-		export[i] = AvatarExportResponse{
-			AvatarId:   avatars[i].AvatarId,
-			AvatarName: avatars[i].AvatarName,
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Model(&models.AvatarFavorite{}).
+			Where("user_id = ? AND deleted_at = ?", r.UserId, r.DeletedAt).
+			Where("avatar_id NOT IN (?)", tx.Model(&models.AvatarFavorite{}).Select("avatar_id").Where("user_id = ?", r.UserId)).
+			Session(&gorm.Session{})
+
+		if err := query.Pluck("avatar_id", &avatarIds).Error; err != nil {
+			return err
+		}
+
+		if len(avatarIds) == 0 {
+			return nil
+		}
+
+		if err := query.UpdateColumns(map[string]interface{}{"deleted_at": nil, "updated_at": time.Now()}).Error; err != nil {
+			return err
 		}
+
+		return AdjustFavoriteCounts(tx, avatarIds, 1)
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if len(avatarIds) == 0 {
+		return c.Status(http.StatusNotFound).JSON(ErrNoDeletedFavorites)
+	}
+
+	go SyncSearchFavoriteCounts(avatarIds)
+
+	RecordAudit(c, "favorites.restore", "user", r.UserId, nil, fiber.Map{"favorites": AuditFavoritesSnapshot(r.UserId)})
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"restored": len(avatarIds)})
+}
+
+func ExportFavoritesAdmin(c *fiber.Ctx) error {
+	var r GenericUserRequest
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	e, err := BuildFavoriteExport(r.UserId)
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	return c.JSON(export)
+	RecordAudit(c, "favorites.export", "user", r.UserId, nil, nil)
+
+	return SendFavoriteExport(c, e)
 }
 
 func WipeUserFavorites(c *fiber.Ctx) error {
@@ -148,19 +256,37 @@ func WipeUserFavorites(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
 	}
 
-	tx := DatabaseConnection.Where("user_id = ?", r.UserId).Delete(&models.AvatarFavorite{})
+	before := AuditFavoritesSnapshot(r.UserId)
 
-	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+	var avatarIds []string
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.AvatarFavorite{}).Where("user_id = ?", r.UserId).Pluck("avatar_id", &avatarIds).Error
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", r.UserId).Delete(&models.AvatarFavorite{}).Error; err != nil {
+			return err
+		}
+
+		return AdjustFavoriteCounts(tx, avatarIds, -1)
+	})
+
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
+	go SyncSearchFavoriteCounts(avatarIds)
+
+	RecordAudit(c, "favorites.wipe", "user", r.UserId, fiber.Map{"favorites": before}, fiber.Map{"favorites": []string{}})
+
 	return c.Status(http.StatusNoContent).JSON(fiber.Map{})
 }
 
 func TransferUserFavorites(c *fiber.Ctx) error {
 	var t TransferRequest
-	var oFavorite, favorite []models.AvatarFavorite
-
 	var ou, tu models.User
 
 	if err := c.BodyParser(&t); err != nil {
@@ -183,32 +309,29 @@ func TransferUserFavorites(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	tx = DatabaseConnection.Where("user_id = ?", t.UserId).Find(&oFavorite)
+	var count int64
 
-	if len(oFavorite) == 0 {
-		return c.Status(http.StatusNoContent).JSON(fiber.Map{})
-	}
+	tx = DatabaseConnection.Model(&models.AvatarFavorite{}).Where("user_id = ?", t.UserId).Count(&count)
 
-	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+	if tx.Error != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	tx = DatabaseConnection.Where("user_id = ?", t.UserId).Find(&favorite)
-
-	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
-		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	if count == 0 {
+		return c.Status(http.StatusNoContent).JSON(fiber.Map{})
 	}
 
-	for _, f := range favorite {
-		f.UserId = t.TargetUserId
-		tx = DatabaseConnection.Save(&f)
+	before := fiber.Map{"source": AuditFavoritesSnapshot(t.UserId), "target": AuditFavoritesSnapshot(t.TargetUserId)}
 
-		if tx.Error != nil {
-			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
-		}
+	j, err := EnqueueJob(JobTransferFavorites, t, GetAdminName(c))
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	return c.JSON(fiber.Map{})
+	RecordAudit(c, "favorites.transfer", "user", t.UserId, before, fiber.Map{"job_id": j.JobId})
+
+	return c.Status(http.StatusAccepted).JSON(j.GetJobResponse())
 }
 
 func ResetUserPin(c *fiber.Ctx) error {
@@ -227,6 +350,7 @@ func ResetUserPin(c *fiber.Ctx) error {
 		return c.Status(http.StatusNotFound).JSON(ErrUserNotFound)
 	}
 
+	before := AuditUserSnapshot(&u)
 	u.UserPin = ""
 
 	tx = DatabaseConnection.Save(&u)
@@ -235,6 +359,8 @@ func ResetUserPin(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
+	RecordAudit(c, "user.reset_pin", "user", r.UserId, before, AuditUserSnapshot(&u))
+
 	return c.Status(http.StatusOK).JSON(fiber.Map{})
 }
 
@@ -248,10 +374,10 @@ func UnBlacklistAvatarAuthor(c *fiber.Ctx) error {
 
 	tx := DatabaseConnection.Where("user_id = ?", r.UserId).First(&b)
 
-	if tx.Error != gorm.ErrRecordNotFound {
-		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
-	} else if tx.Error == gorm.ErrRecordNotFound {
+	if tx.Error == gorm.ErrRecordNotFound {
 		return c.Status(http.StatusBadRequest).JSON(ErrUserIsNotBlacklisted)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
 	tx = DatabaseConnection.Delete(&b)
@@ -260,28 +386,15 @@ func UnBlacklistAvatarAuthor(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	var a []models.Avatar
+	j, err := EnqueueJob(JobReindexAuthor, r, GetAdminName(c))
 
-	tx = DatabaseConnection.Where("avatar_author_id = ?", r.UserId).Find(&a)
-
-	if tx.Error != gorm.ErrRecordNotFound {
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	for _, avatar := range a {
-		l := *avatar.GetLimitedAvatar()
-		res, err := ReJsonClient.JSONSet(l.AvatarId, "$", l)
+	RecordAudit(c, "author.unblacklist", "author", r.UserId, fiber.Map{"blacklisted": true}, fiber.Map{"blacklisted": false, "job_id": j.JobId})
 
-		if err != nil {
-			fmt.Println(err)
-		}
-
-		if res.(string) != "OK" {
-			fmt.Printf("Error adding avatar to search index: %s\n", l.AvatarId)
-		}
-	}
-
-	return c.Status(http.StatusOK).JSON(fiber.Map{})
+	return c.Status(http.StatusAccepted).JSON(j.GetJobResponse())
 }
 
 func BlacklistAvatarAuthor(c *fiber.Ctx) error {
@@ -306,25 +419,15 @@ func BlacklistAvatarAuthor(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(ErrUserAlreadyBlacklisted)
 	}
 
-	var a []models.Avatar
-
-	tx = DatabaseConnection.Where("avatar_author_id = ?", r.UserId).Find(&a)
+	j, err := EnqueueJob(JobUnindexAuthor, r, GetAdminName(c))
 
-	if tx.Error == gorm.ErrRecordNotFound {
-		return c.Status(http.StatusOK).JSON(fiber.Map{})
-	} else if tx.Error != nil {
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	for _, avatar := range a {
-		_, err := ReJsonClient.JSONDel(avatar.AvatarIdSha256, "$")
+	RecordAudit(c, "author.blacklist", "author", r.UserId, fiber.Map{"blacklisted": false}, fiber.Map{"blacklisted": true, "job_id": j.JobId})
 
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
-		}
-	}
-
-	return c.Status(http.StatusOK).JSON(fiber.Map{})
+	return c.Status(http.StatusAccepted).JSON(j.GetJobResponse())
 }
 
 func BlacklistAvatar(c *fiber.Ctx) error {
@@ -344,6 +447,7 @@ func BlacklistAvatar(c *fiber.Ctx) error {
 		return c.Status(http.StatusNotFound).JSON(fiber.Map{})
 	}
 
+	before := a
 	a.AvatarPublic = false
 
 	tx = DatabaseConnection.Save(&a)
@@ -352,12 +456,22 @@ func BlacklistAvatar(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	_, err := ReJsonClient.JSONDel(a.AvatarIdSha256, "$")
+	RecordAudit(c, "avatar.blacklist", "avatar", a.AvatarId, before, a)
+
+	err := DeleteSearchDocument(a.AvatarIdSha256)
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
+	InvalidateSearchCache()
+
+	if before.AvatarPublic {
+		if err := RemoveSuggestions(&before); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+	}
+
 	return c.Status(http.StatusOK).JSON(fiber.Map{})
 }
 
@@ -382,90 +496,61 @@ func GetAdminAvatar(c *fiber.Ctx) error {
 }
 
 func RebuildSearchIndex(c *fiber.Ctx) error {
-	var a []models.Avatar
-
-	_, err := RedisConnection.Get(ctx, "rebuild_search_index").Int()
+	j, err := EnqueueUniqueJob(JobRebuildSearchIndex, nil, GetAdminName(c))
 
-	if err == nil {
+	if err == ErrJobAlreadyActive {
 		return c.Status(http.StatusBadRequest).JSON(ErrAlreadyRebuilding)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	RedisConnection.FlushAll(ctx)
-	cmd := RedisConnection.Do(ctx, "FT.CREATE",
-		"avatarSearch", "ON", "JSON", "SCHEMA", "$.avatar_name", "AS", "avatar_name", "TEXT", "$.avatar_author_name ", "AS", "avatar_author_name", "TEXT")
-	cmd.Val()
-
-	go func() {
-		RedisConnection.Set(ctx, "rebuild_search_index", 0, 0)
-
-		var b []models.BlacklistedAuthor
-
-		tx := DatabaseConnection.Find(&b)
-
-		if tx.Error != nil {
-			return
-		}
-
-		DatabaseConnection.Where("avatar_public = ?", "t").FindInBatches(&a, 1000, func(tx *gorm.DB, batch int) error {
-			l := make([]models.LimitedAvatar, len(a))
-			i := 0
+	RecordAudit(c, "search.rebuild", "search_index", SearchIndexAlias, nil, fiber.Map{"job_id": j.JobId})
 
-			for _, result := range a {
-				l[i] = *result.GetLimitedAvatar()
-				i++
-			}
-
-			for _, v := range l {
-				shouldIndex := true
-				for _, b := range b {
-					if v.AvatarAuthorId == b.UserId {
-						shouldIndex = false
-						break
-					}
-				}
-
-				if !shouldIndex {
-					continue
-				}
-
-				res, err := ReJsonClient.JSONSet(v.AvatarId, "$", v)
+	return c.Status(http.StatusAccepted).JSON(j.GetJobResponse())
+}
 
-				if err != nil {
-					fmt.Println(err)
-				}
+func RebuildSearchIndexStatus(c *fiber.Ctx) error {
+	j, err := GetActiveJob(JobRebuildSearchIndex)
 
-				if res.(string) != "OK" {
-					fmt.Printf("Error adding avatar to search index: %s\n", v.AvatarId)
-				}
-			}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
 
-			fmt.Printf("Processed Batch: %d\n", batch)
-			RedisConnection.Set(ctx, "rebuild_search_index", batch, 0)
+	if j == nil {
+		return c.Status(http.StatusNoContent).JSON(fiber.Map{})
+	}
 
-			return nil
-		})
+	return c.Status(http.StatusOK).JSON(j.GetJobResponse())
+}
 
-		fmt.Println("Finished rebuilding search index")
-		RedisConnection.Del(ctx, "rebuild_search_index")
-	}()
+func ReconcileSearchIndex(c *fiber.Ctx) error {
+	var r SearchReconcileRequest
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{})
-}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&r); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+		}
+	}
 
-func RebuildSearchIndexStatus(c *fiber.Ctx) error {
-	batch, err := RedisConnection.Get(ctx, "rebuild_search_index").Result()
+	j, err := EnqueueUniqueJob(JobReconcileSearch, r, GetAdminName(c))
 
-	if err == redis.Nil {
-		return c.Status(http.StatusNoContent).JSON(fiber.Map{})
+	if err == ErrJobAlreadyActive {
+		return c.Status(http.StatusBadRequest).JSON(ErrAlreadyReconciling)
 	} else if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	batchInt, err := strconv.Atoi(batch)
+	RecordAudit(c, "search.reconcile", "search_index", SearchIndexAlias, nil, fiber.Map{"job_id": j.JobId, "repair": r.Repair})
+
+	return c.Status(http.StatusAccepted).JSON(j.GetJobResponse())
+}
+
+func GetSearchCacheStatus(c *fiber.Ctx) error {
+	stats, err := GetSearchCacheStats()
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{"batch": batchInt})
+	return c.Status(http.StatusOK).JSON(stats)
 }
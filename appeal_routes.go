@@ -0,0 +1,214 @@
+package main
+
+import (
+	"emmApi/models"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxAppealMessageLength = 2000
+
+var errAppealReviewed = errors.New("appeal already reviewed")
+
+var ErrAppealNotFound = fiber.Map{"error": "Appeal not found."}
+var ErrAppealAlreadyExists = fiber.Map{"error": "An appeal has already been submitted for this ban."}
+var ErrAppealAlreadyReviewed = fiber.Map{"error": "Appeal has already been reviewed."}
+var ErrAppealMessageInvalid = fiber.Map{"error": "Appeal message must be between 1 and 2000 characters."}
+var ErrBanNotActive = fiber.Map{"error": "Ban is no longer active."}
+var ErrInvalidAppealStatus = fiber.Map{"error": "Appeal status must be one of pending, accepted, rejected or all."}
+
+func appealRoutes(router fiber.Router) {
+	// The ban ID is only ever handed to the banned user, so it doubles as the credential here
+	router.Post("/ban/appeal", SubmitBanAppeal)
+	router.Get("/ban/appeal/:ban_id", GetBanAppealStatus)
+
+	router.Get("/admin/appeals", RequireAdmin(models.RoleViewer), ListBanAppeals)
+	router.Post("/admin/appeals/:appeal_id/accept", RequireAdmin(models.RoleModerator), AcceptBanAppeal)
+	router.Post("/admin/appeals/:appeal_id/reject", RequireAdmin(models.RoleModerator), RejectBanAppeal)
+}
+
+func SubmitBanAppeal(c *fiber.Ctx) error {
+	var r BanAppealRequest
+	var b models.Ban
+	var a models.BanAppeal
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if r.AppealMessage == "" || utf8.RuneCountInString(r.AppealMessage) > MaxAppealMessageLength {
+		return c.Status(http.StatusBadRequest).JSON(ErrAppealMessageInvalid)
+	}
+
+	tx := DatabaseConnection.Where("ban_id = ?", r.BanId).First(&b)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrBanNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if !b.IsActive() {
+		return c.Status(http.StatusBadRequest).JSON(ErrBanNotActive)
+	}
+
+	tx = DatabaseConnection.Where("ban_id = ?", r.BanId).First(&a)
+
+	if tx.Error == nil {
+		return c.Status(http.StatusConflict).JSON(ErrAppealAlreadyExists)
+	} else if tx.Error != gorm.ErrRecordNotFound {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	appealId, err := GenerateId("appeal_")
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	a = models.BanAppeal{
+		AppealId:      appealId,
+		BanId:         b.BanId,
+		AppealMessage: r.AppealMessage,
+		AppealStatus:  models.AppealPending,
+		AppealCreated: time.Now(),
+		AppealUpdated: time.Now(),
+	}
+
+	// the unique index on ban_id settles two appeals racing past the check above
+	tx = DatabaseConnection.Clauses(clause.OnConflict{DoNothing: true}).Create(&a)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if tx.RowsAffected == 0 {
+		return c.Status(http.StatusConflict).JSON(ErrAppealAlreadyExists)
+	}
+
+	return c.Status(http.StatusCreated).JSON(a)
+}
+
+func GetBanAppealStatus(c *fiber.Ctx) error {
+	var a models.BanAppeal
+
+	tx := DatabaseConnection.Where("ban_id = ?", c.Params("ban_id")).First(&a)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrAppealNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(a)
+}
+
+func ListBanAppeals(c *fiber.Ctx) error {
+	var q BanAppealListQuery
+	var appeals []models.BanAppeal
+	var total int64
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	q.Page, q.Limit = ClampPagination(q.Page, q.Limit)
+	tx := DatabaseConnection.Model(&models.BanAppeal{})
+
+	switch models.AppealStatus(q.Status) {
+	case "all":
+	case "":
+		tx = tx.Where("appeal_status = ?", models.AppealPending)
+	case models.AppealPending, models.AppealAccepted, models.AppealRejected:
+		tx = tx.Where("appeal_status = ?", q.Status)
+	default:
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidAppealStatus)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	err := tx.Preload("Ban").Order("appeal_created ASC").Offset((q.Page - 1) * q.Limit).Limit(q.Limit).Find(&appeals).Error
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	res := BanAppealListResponse{
+		Appeals: make([]models.AdminBanAppeal, len(appeals)),
+		Page:    q.Page,
+		Limit:   q.Limit,
+		Total:   total,
+	}
+
+	for i := range appeals {
+		res.Appeals[i] = *appeals[i].GetAdminBanAppeal()
+	}
+
+	return c.Status(http.StatusOK).JSON(res)
+}
+
+func AcceptBanAppeal(c *fiber.Ctx) error {
+	return ReviewBanAppeal(c, models.AppealAccepted)
+}
+
+func RejectBanAppeal(c *fiber.Ctx) error {
+	return ReviewBanAppeal(c, models.AppealRejected)
+}
+
+// ReviewBanAppeal settles a pending appeal, accepted appeals lift the ban in the same transaction
+func ReviewBanAppeal(c *fiber.Ctx, status models.AppealStatus) error {
+	var r BanAppealReviewRequest
+	var a models.BanAppeal
+	var before *models.AdminBanAppeal
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Ban").
+			Where("appeal_id = ?", c.Params("appeal_id")).First(&a).Error
+
+		if err != nil {
+			return err
+		}
+
+		if a.AppealStatus != models.AppealPending {
+			return errAppealReviewed
+		}
+
+		before = a.GetAdminBanAppeal()
+
+		if status == models.AppealAccepted && a.Ban != nil {
+			if err := ExpireBan(tx, a.Ban); err != nil {
+				return err
+			}
+		}
+
+		a.AppealStatus = status
+		a.AppealResponse = r.AppealResponse
+		a.AppealReviewer = GetAdminName(c)
+		a.AppealUpdated = time.Now()
+
+		return tx.Omit("Ban").Save(&a).Error
+	})
+
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrAppealNotFound)
+	} else if err == errAppealReviewed {
+		return c.Status(http.StatusConflict).JSON(ErrAppealAlreadyReviewed)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "appeal."+string(status), "appeal", a.AppealId, before, a.GetAdminBanAppeal())
+
+	return c.Status(http.StatusOK).JSON(a.GetAdminBanAppeal())
+}
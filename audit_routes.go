@@ -0,0 +1,162 @@
+package main
+
+import (
+	"emmApi/models"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"time"
+)
+
+var ErrInvalidTimeRange = fiber.Map{"error": "Invalid time range, expected RFC 3339 timestamps."}
+
+func auditRoutes(router fiber.Router) {
+	router.Get("/admin/audit", RequireAdmin(models.RoleModerator), ListAuditLog)
+}
+
+// RecordAudit appends an entry for the admin action being handled by c. A failed write is
+// logged rather than failing the request since the action itself has already happened.
+func RecordAudit(c *fiber.Ctx, action string, targetType string, targetId string, before interface{}, after interface{}) {
+	actorId, _ := c.Locals("adminId").(string)
+
+	entry := models.AuditLog{
+		ActorId:    actorId,
+		ActorName:  GetAdminName(c),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Payload:    AuditPayload(c.Body()),
+		SourceIp:   c.IP(),
+		Before:     AuditSnapshot(before),
+		After:      AuditSnapshot(after),
+		CreatedAt:  time.Now(),
+	}
+
+	tx := DatabaseConnection.Create(&entry)
+
+	if tx.Error != nil {
+		fmt.Printf("Error writing audit log for %s on %s: %s\n", action, targetId, tx.Error)
+	}
+}
+
+// AuditPayload stores the raw request body, wrapping it as a JSON string when it isn't JSON itself
+func AuditPayload(body []byte) string {
+	if len(body) == 0 {
+		return "null"
+	}
+
+	if json.Valid(body) {
+		return string(body)
+	}
+
+	return AuditSnapshot(string(body))
+}
+
+func AuditSnapshot(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return "null"
+	}
+
+	return string(data)
+}
+
+func ListAuditLog(c *fiber.Ctx) error {
+	var q AuditListQuery
+	var entries []models.AuditLog
+	var total int64
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	q.Page, q.Limit = ClampPagination(q.Page, q.Limit)
+	tx := DatabaseConnection.Model(&models.AuditLog{})
+
+	if q.Actor != "" {
+		tx = tx.Where("actor_id = ? OR actor_name = ?", q.Actor, q.Actor)
+	}
+
+	if q.Target != "" {
+		tx = tx.Where("target_id = ?", q.Target)
+	}
+
+	if q.Action != "" {
+		tx = tx.Where("action = ?", q.Action)
+	}
+
+	if q.From != "" {
+		from, err := time.Parse(time.RFC3339, q.From)
+
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidTimeRange)
+		}
+
+		tx = tx.Where("created_at >= ?", from)
+	}
+
+	if q.To != "" {
+		to, err := time.Parse(time.RFC3339, q.To)
+
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidTimeRange)
+		}
+
+		tx = tx.Where("created_at < ?", to)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	err := tx.Order("id DESC").Offset((q.Page - 1) * q.Limit).Limit(q.Limit).Find(&entries).Error
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	res := AuditListResponse{
+		Entries: make([]models.AuditEntry, len(entries)),
+		Page:    q.Page,
+		Limit:   q.Limit,
+		Total:   total,
+	}
+
+	for i := range entries {
+		res.Entries[i] = *entries[i].GetAuditEntry()
+	}
+
+	return c.Status(http.StatusOK).JSON(res)
+}
+
+// AuditUserSnapshot captures a user without their pin hash
+func AuditUserSnapshot(u *models.User) fiber.Map {
+	return fiber.Map{
+		"user_id":        u.UserId,
+		"known_aliases":  u.UserKnownAliases,
+		"has_vrc_plus":   u.HasVRCPlus,
+		"favorite_limit": u.FavoriteLimit,
+		"has_pin":        u.UserPin != "",
+		"last_seen":      u.LastSeen,
+		"created_at":     u.CreatedAt,
+	}
+}
+
+// AuditFavoritesSnapshot lists the avatar IDs a user has favorited, oldest first
+func AuditFavoritesSnapshot(userId string) []string {
+	var avatarIds []string
+
+	tx := DatabaseConnection.Model(&models.AvatarFavorite{}).Where("user_id = ?", userId).Order("id ASC").Pluck("avatar_id", &avatarIds)
+
+	if tx.Error != nil {
+		fmt.Printf("Error snapshotting favorites for audit log: %s\n", tx.Error)
+	}
+
+	return avatarIds
+}
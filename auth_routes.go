@@ -33,6 +33,7 @@ var ErrUserIdDoesNotMatchPattern = fiber.Map{"error": "User ID does not match pa
 var ErrPasswordDoesNotMatchPattern = fiber.Map{"error": "Password does not match required pattern."}
 var ErrInvalidPassword = fiber.Map{"error": "Invalid password."}
 var ErrPasswordResetRequired = fiber.Map{"error": "Password reset required."}
+var ErrAccountDeleted = fiber.Map{"error": "This account has been deleted."}
 
 var LetterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
 
@@ -74,6 +75,19 @@ func doAuth(c *fiber.Ctx) error {
 	tx := DatabaseConnection.Where("user_id = ?", a.UserId).First(&u)
 
 	if tx.Error == gorm.ErrRecordNotFound {
+		var count int64
+
+		// A deleted account stays locked until an admin restores it or the purge removes it
+		tx = DatabaseConnection.Unscoped().Model(&models.User{}).Where("user_id = ? AND deleted_at IS NOT NULL", a.UserId).Count(&count)
+
+		if tx.Error != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		if count > 0 {
+			return c.Status(http.StatusGone).JSON(ErrAccountDeleted)
+		}
+
 		hash, err := argon2id.CreateHash(a.Password, &Argon2IdParams)
 
 		if err != nil {
@@ -155,7 +169,7 @@ func doAuth(c *fiber.Ctx) error {
 
 	ban := GetBan(u.UserId, c.IP())
 
-	if ban != nil {
+	if ban != nil && !ban.IsShadow {
 		return c.Status(http.StatusForbidden).JSON(ban)
 	}
 
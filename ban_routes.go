@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"emmApi/models"
+	"encoding/hex"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"time"
+)
+
+var ErrBanNotFound = fiber.Map{"error": "Ban not found."}
+var ErrBanTargetRequired = fiber.Map{"error": "A user ID, IP address or IP range is required."}
+var ErrInvalidIpAddress = fiber.Map{"error": "Invalid IP address."}
+var ErrInvalidIpRange = fiber.Map{"error": "Invalid IP range, expected CIDR notation."}
+var ErrBanExpiryInPast = fiber.Map{"error": "Ban expiry must be in the future."}
+var ErrInvalidBanStatus = fiber.Map{"error": "Ban status must be one of active, expired or all."}
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+func banRoutes(router fiber.Router) {
+	router.Get("/admin/bans", RequireAdmin(models.RoleViewer), ListBans)
+	router.Post("/admin/bans", RequireAdmin(models.RoleModerator), CreateBan)
+	router.Get("/admin/bans/lookup", RequireAdmin(models.RoleViewer), LookupBan)
+	router.Get("/admin/bans/:ban_id", RequireAdmin(models.RoleViewer), GetAdminBan)
+	router.Patch("/admin/bans/:ban_id", RequireAdmin(models.RoleModerator), UpdateBan)
+	router.Delete("/admin/bans/:ban_id", RequireAdmin(models.RoleModerator), LiftBan)
+}
+
+func GenerateBanId() (string, error) {
+	return GenerateId("ban_")
+}
+
+func GenerateId(prefix string) (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// ClampPagination normalises page (1-indexed) and limit query values
+func ClampPagination(page int, limit int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+
+	if limit < 1 {
+		limit = DefaultPageLimit
+	} else if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	return page, limit
+}
+
+func ListBans(c *fiber.Ctx) error {
+	var q BanListQuery
+	var bans []models.Ban
+	var total int64
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	q.Page, q.Limit = ClampPagination(q.Page, q.Limit)
+	tx := DatabaseConnection.Model(&models.Ban{})
+
+	if q.UserId != "" {
+		tx = tx.Where("ban_user_id = ?", q.UserId)
+	}
+
+	switch q.Status {
+	case "", "all":
+	case "active":
+		tx = tx.Where("ban_expires <= ? OR ban_expires >= ?", time.UnixMilli(0), time.Now())
+	case "expired":
+		tx = tx.Where("ban_expires > ? AND ban_expires < ?", time.UnixMilli(0), time.Now())
+	default:
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidBanStatus)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	err := tx.Order("ban_created DESC").Offset((q.Page - 1) * q.Limit).Limit(q.Limit).Find(&bans).Error
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	res := BanListResponse{
+		Bans:  make([]models.AdminBan, len(bans)),
+		Page:  q.Page,
+		Limit: q.Limit,
+		Total: total,
+	}
+
+	for i := range bans {
+		res.Bans[i] = *bans[i].GetAdminBan()
+	}
+
+	return c.Status(http.StatusOK).JSON(res)
+}
+
+func CreateBan(c *fiber.Ctx) error {
+	var r BanCreateRequest
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if r.UserId == "" && r.IpAddress == "" && r.IpRange == "" {
+		return c.Status(http.StatusBadRequest).JSON(ErrBanTargetRequired)
+	}
+
+	if r.IpAddress != "" && net.ParseIP(r.IpAddress) == nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidIpAddress)
+	}
+
+	var ipRange *string
+
+	if r.IpRange != "" {
+		_, network, err := net.ParseCIDR(r.IpRange)
+
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidIpRange)
+		}
+
+		// cidr columns reject host bits, so store the canonical network address
+		canonical := network.String()
+		ipRange = &canonical
+	}
+
+	if r.BanExpires != nil && !r.BanExpires.IsZero() && r.BanExpires.Before(time.Now()) {
+		return c.Status(http.StatusBadRequest).JSON(ErrBanExpiryInPast)
+	}
+
+	banId, err := GenerateBanId()
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	b := models.Ban{
+		BanId:      banId,
+		BanUserId:  r.UserId,
+		BanIssuer:  GetAdminName(c),
+		BanReason:  r.BanReason,
+		IpAddress:  r.IpAddress,
+		IpRange:    ipRange,
+		IsShadow:   r.IsShadow,
+		BanUpdated: time.Now(),
+		BanCreated: time.Now(),
+	}
+
+	if r.BanExpires != nil {
+		b.BanExpires = *r.BanExpires
+	}
+
+	tx := DatabaseConnection.Create(&b)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "ban.create", "ban", b.BanId, nil, b.GetAdminBan())
+
+	return c.Status(http.StatusCreated).JSON(b.GetAdminBan())
+}
+
+// LookupBan reports the ban that would apply to a user ID and/or IP address and what it matched on
+func LookupBan(c *fiber.Ctx) error {
+	var q BanLookupQuery
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if q.UserId == "" && q.IpAddress == "" {
+		return c.Status(http.StatusBadRequest).JSON(ErrBanTargetRequired)
+	}
+
+	if q.IpAddress != "" && net.ParseIP(q.IpAddress) == nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidIpAddress)
+	}
+
+	match := FindBan(q.UserId, q.IpAddress)
+
+	if match == nil {
+		return c.Status(http.StatusNotFound).JSON(ErrBanNotFound)
+	}
+
+	return c.Status(http.StatusOK).JSON(BanLookupResponse{
+		Ban:          match.Ban.GetAdminBan(),
+		MatchedOn:    match.MatchedOn,
+		MatchedRange: match.MatchedRange,
+	})
+}
+
+func GetAdminBan(c *fiber.Ctx) error {
+	var b models.Ban
+
+	tx := DatabaseConnection.Where("ban_id = ?", c.Params("ban_id")).First(&b)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrBanNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(b.GetAdminBan())
+}
+
+func UpdateBan(c *fiber.Ctx) error {
+	var r BanUpdateRequest
+	var b models.Ban
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	tx := DatabaseConnection.Where("ban_id = ?", c.Params("ban_id")).First(&b)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrBanNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	before := b.GetAdminBan()
+
+	if r.BanReason != nil {
+		b.BanReason = *r.BanReason
+	}
+
+	if r.IsShadow != nil {
+		b.IsShadow = *r.IsShadow
+	}
+
+	if r.Permanent {
+		b.BanExpires = time.Time{}
+	} else if r.BanExpires != nil {
+		if r.BanExpires.Before(time.Now()) {
+			return c.Status(http.StatusBadRequest).JSON(ErrBanExpiryInPast)
+		}
+
+		b.BanExpires = *r.BanExpires
+	}
+
+	b.BanUpdated = time.Now()
+	tx = DatabaseConnection.Save(&b)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "ban.update", "ban", b.BanId, before, b.GetAdminBan())
+
+	return c.Status(http.StatusOK).JSON(b.GetAdminBan())
+}
+
+// LiftBan expires the ban immediately rather than deleting it so the history is kept
+func LiftBan(c *fiber.Ctx) error {
+	var b models.Ban
+
+	tx := DatabaseConnection.Where("ban_id = ?", c.Params("ban_id")).First(&b)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrBanNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	before := b.GetAdminBan()
+
+	if err := ExpireBan(DatabaseConnection, &b); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "ban.lift", "ban", b.BanId, before, b.GetAdminBan())
+
+	return c.Status(http.StatusOK).JSON(b.GetAdminBan())
+}
+
+func ExpireBan(tx *gorm.DB, b *models.Ban) error {
+	b.BanExpires = time.Now().Add(-time.Second)
+	b.BanUpdated = time.Now()
+
+	return tx.Save(b).Error
+}
@@ -8,6 +8,9 @@ type Config struct {
 	Redis        RedisConfig        `json:"redis"`
 	Jwt          JwtConfig          `json:"jwt"`
 	CheckService CheckServiceConfig `json:"check_service"`
+	Retention    RetentionConfig    `json:"retention"`
+	Search       SearchConfig       `json:"search"`
+	Quota        QuotaConfig        `json:"quota"`
 }
 
 type DatabaseConfig struct {
@@ -35,3 +38,23 @@ type CheckServiceConfig struct {
 	CheckEnabled bool   `json:"check_enabled"`
 	CheckUrl     string `json:"check_url"`
 }
+
+type RetentionConfig struct {
+	DeletedRetentionDays int `json:"deleted_retention_days"`
+}
+
+type SearchConfig struct {
+	// Backend is either redisearch or postgres, the other one is only used when it fails
+	Backend         string `json:"backend"`
+	DisableFailover bool   `json:"disable_failover"`
+	// ReconcileIntervalHours schedules the index consistency check, 0 leaves it to admins
+	ReconcileIntervalHours int  `json:"reconcile_interval_hours"`
+	ReconcileRepair        bool `json:"reconcile_repair"`
+	CacheTtlSeconds        int  `json:"cache_ttl_seconds"`
+	DisableCache           bool `json:"disable_cache"`
+}
+
+type QuotaConfig struct {
+	DefaultFavoriteLimit int `json:"default_favorite_limit"`
+	VRCPlusFavoriteLimit int `json:"vrc_plus_favorite_limit"`
+}
@@ -44,6 +44,16 @@ func SetupDatabaseConnection() {
 	sqlDb.SetMaxIdleConns(databaseConfig.MaxIdleConnections)
 	sqlDb.SetMaxOpenConns(databaseConfig.MaxOpenConnections)
 
+	backfillFavoriteCounts := !db.Migrator().HasColumn(&models.Avatar{}, "FavoriteCount")
+
+	// is_shadowed was first added as a nullable column, its NULLs have to go before it can become NOT NULL
+	if IsNullableColumn(db, &models.Avatar{}, "is_shadowed") {
+		err = db.Exec("UPDATE avatars SET is_shadowed = false WHERE is_shadowed IS NULL").Error
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
 	err = db.AutoMigrate(&models.Avatar{})
 	if err != nil {
 		fmt.Println(err)
@@ -69,11 +79,64 @@ func SetupDatabaseConnection() {
 		fmt.Println(err)
 	}
 
+	backfillFavoriteUpdates := !db.Migrator().HasColumn(&models.AvatarFavorite{}, "UpdatedAt")
+
 	err = db.AutoMigrate(&models.AvatarFavorite{})
 	if err != nil {
 		fmt.Println(err)
 	}
 
+	if backfillFavoriteUpdates {
+		err = db.Exec("UPDATE avatar_favorites SET updated_at = created_at WHERE updated_at IS NULL").Error
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	err = db.AutoMigrate(&models.BanAppeal{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.AdminPrincipal{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.AdminApiKey{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.AuditLog{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.Job{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.FavoriteList{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = db.AutoMigrate(&models.FavoriteListEntry{})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	SetupSearchIndexes(db)
+	SetupFavoriteIndexes(db)
+
+	if backfillFavoriteCounts {
+		if err := RecountFavorites(db); err != nil {
+			fmt.Println(err)
+		}
+	}
+
 	DatabaseConnection = db
 }
 
@@ -91,3 +154,20 @@ func SetupRedisConnection() {
 	ReJsonClient = rh
 	RediSearchClient = rs
 }
+
+func IsNullableColumn(db *gorm.DB, model interface{}, column string) bool {
+	columnTypes, err := db.Migrator().ColumnTypes(model)
+
+	if err != nil {
+		return false
+	}
+
+	for _, c := range columnTypes {
+		if c.Name() == column {
+			nullable, ok := c.Nullable()
+			return ok && nullable
+		}
+	}
+
+	return false
+}
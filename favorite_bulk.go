@@ -0,0 +1,388 @@
+package main
+
+import (
+	"emmApi/models"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+const MaxBulkFavorites = 500
+
+const (
+	FavoriteStatusAdded      = "added"
+	FavoriteStatusRemoved    = "removed"
+	FavoriteStatusDuplicate  = "duplicate"
+	FavoriteStatusInvalid    = "invalid"
+	FavoriteStatusNotFound   = "not_found"
+	FavoriteStatusConflict   = "conflict"
+	FavoriteStatusFailed     = "failed"
+	FavoriteStatusQuota      = "quota_exceeded"
+	FavoriteStatusRolledBack = "rolled_back"
+)
+
+var errBulkStopped = errors.New("bulk favorite request stopped on error")
+
+var ErrInvalidBulkFavorites = fiber.Map{"error": fmt.Sprintf("Bulk requests must contain between 1 and %d avatars.", MaxBulkFavorites)}
+
+// AddFavoriteEntry adds one favorite using tx, creating the avatar when we don't know it yet.
+// Entries that can't be added come back with their status and a nil error, the error is only
+// set when tx can't be used any further. Avatars it creates are returned so the caller can
+// index them once tx is committed.
+func AddFavoriteEntry(tx *gorm.DB, c *fiber.Ctx, f *AvatarFavoriteRequest, source models.AvatarSource, position int64, seen map[string]bool) (FavoriteItemResult, *models.Avatar, error) {
+	var a models.Avatar
+	var fa models.AvatarFavorite
+	var created *models.Avatar
+
+	userId := c.Locals("userId").(string)
+	result := FavoriteItemResult{AvatarId: f.AvatarId}
+
+	if f.AvatarId == "" {
+		result.Status = FavoriteStatusInvalid
+		result.Error = "Missing avatar_id."
+		return result, nil, nil
+	}
+
+	if seen[f.AvatarId] {
+		result.Status = FavoriteStatusDuplicate
+		return result, nil, nil
+	}
+
+	seen[f.AvatarId] = true
+
+	err := tx.Where("avatar_id = ?", f.AvatarId).First(&a).Error
+
+	if err == gorm.ErrRecordNotFound {
+		if f.AvatarAssetUrl == "" {
+			result.Status = FavoriteStatusNotFound
+			result.Error = "Unknown avatar, the entry needs the full avatar details to be added."
+			return result, nil, nil
+		}
+
+		if !AssetUrlRegex.MatchString(f.AvatarAssetUrl) {
+			result.Status = FavoriteStatusInvalid
+			result.Error = ErrInvalidAssetUrl["error"].(string)
+			return result, nil, nil
+		}
+
+		err = tx.Where("avatar_thumbnail_url = ?", f.AvatarThumbnailUrl).First(&a).Error
+
+		if err == nil {
+			result.Status = FavoriteStatusConflict
+			result.Error = ErrResourceSharingConflict["error"].(string)
+			return result, nil, nil
+		} else if err != gorm.ErrRecordNotFound {
+			result.Status = FavoriteStatusFailed
+			return result, nil, err
+		}
+
+		a = NewAvatar(f, source, userId, IsShadowBanned(c))
+
+		if err := tx.Create(&a).Error; err != nil {
+			result.Status = FavoriteStatusFailed
+			return result, nil, err
+		}
+
+		created = &a
+	} else if err != nil {
+		result.Status = FavoriteStatusFailed
+		return result, nil, err
+	}
+
+	err = tx.Where("user_id = ? AND avatar_id = ?", userId, a.AvatarId).First(&fa).Error
+
+	if err == nil {
+		result.Status = FavoriteStatusDuplicate
+		return result, created, nil
+	} else if err != gorm.ErrRecordNotFound {
+		result.Status = FavoriteStatusFailed
+		return result, nil, err
+	}
+
+	if err := EnforceFavoriteQuota(tx, userId, 1); err != nil {
+		var quotaErr *FavoriteQuotaError
+
+		if errors.As(err, &quotaErr) {
+			result.Status = FavoriteStatusQuota
+			result.Error = quotaErr.Error()
+		} else {
+			result.Status = FavoriteStatusFailed
+		}
+
+		return result, nil, err
+	}
+
+	fa = models.AvatarFavorite{
+		UserId:   userId,
+		AvatarId: a.AvatarId,
+		Position: position,
+	}
+
+	if err := tx.Create(&fa).Error; err != nil {
+		result.Status = FavoriteStatusFailed
+		return result, nil, err
+	}
+
+	if err := AdjustFavoriteCounts(tx, []string{a.AvatarId}, 1); err != nil {
+		result.Status = FavoriteStatusFailed
+		return result, nil, err
+	}
+
+	result.Status = FavoriteStatusAdded
+	return result, created, nil
+}
+
+// AddFavoriteEntries adds every entry using tx, each behind a savepoint so a failed entry only
+// undoes itself. The user row is locked before the positions are picked, so nothing else can
+// add favorites in between and take the same ones.
+func AddFavoriteEntries(tx *gorm.DB, c *fiber.Ctx, entries []AvatarFavoriteRequest, source models.AvatarSource, stopOnError bool) ([]FavoriteItemResult, []*models.Avatar, error) {
+	var created []*models.Avatar
+
+	userId := c.Locals("userId").(string)
+	results := make([]FavoriteItemResult, 0, len(entries))
+
+	if _, err := LockFavoriteUser(tx, userId); err != nil {
+		return nil, nil, err
+	}
+
+	position, err := NextFavoritePosition(tx, userId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the first entry ends up on top, the same as adding them one by one in reverse
+	position -= int64(len(entries)) - 1
+
+	seen := make(map[string]bool, len(entries))
+
+	for i := range entries {
+		if err := tx.SavePoint("favorite").Error; err != nil {
+			return nil, nil, err
+		}
+
+		result, a, err := AddFavoriteEntry(tx, c, &entries[i], source, position+int64(i), seen)
+		results = append(results, result)
+
+		if err != nil {
+			if err := tx.RollbackTo("favorite").Error; err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if stopOnError && IsFavoriteItemError(&result) {
+			return results, created, errBulkStopped
+		}
+
+		if a != nil {
+			created = append(created, a)
+		}
+	}
+
+	return results, created, nil
+}
+
+// IsFavoriteItemError tells whether a result should stop a stop_on_error request
+func IsFavoriteItemError(result *FavoriteItemResult) bool {
+	switch result.Status {
+	case FavoriteStatusAdded, FavoriteStatusRemoved, FavoriteStatusDuplicate:
+		return false
+	}
+
+	return true
+}
+
+func ParseBulkFavorites(c *fiber.Ctx) (*FavoriteBulkRequest, []AvatarFavoriteRequest, bool) {
+	var r FavoriteBulkRequest
+
+	if err := c.BodyParser(&r); err != nil {
+		return nil, nil, false
+	}
+
+	entries := r.Avatars
+
+	for _, avatarId := range r.AvatarIds {
+		entries = append(entries, AvatarFavoriteRequest{AvatarId: avatarId})
+	}
+
+	return &r, entries, true
+}
+
+// BulkAddAvatarFavorites adds every entry in one transaction. Entries that fail are left out
+// and the rest is committed, unless stop_on_error is set in which case nothing is kept.
+func BulkAddAvatarFavorites(c *fiber.Ctx) error {
+	r, entries, ok := ParseBulkFavorites(c)
+
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if len(entries) == 0 || len(entries) > MaxBulkFavorites {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidBulkFavorites)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	if errBody, status := CheckFavoriteAccess(userId); errBody != nil {
+		return c.Status(status).JSON(errBody)
+	}
+
+	var resp FavoriteBulkResponse
+	var created []*models.Avatar
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		resp = FavoriteBulkResponse{}
+		resp.Results, created, err = AddFavoriteEntries(tx, c, entries, models.Favorite, r.StopOnError)
+
+		return err
+	})
+
+	if err == errBulkStopped {
+		resp.RolledBack()
+
+		return c.Status(http.StatusUnprocessableEntity).JSON(resp)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	added := make([]string, 0, len(resp.Results))
+
+	for _, result := range resp.Results {
+		if result.Status == FavoriteStatusAdded {
+			added = append(added, result.AvatarId)
+		}
+	}
+
+	for _, a := range created {
+		if err := IndexAvatar(a); err != nil {
+			fmt.Printf("Error indexing avatar %s: %s\n", a.AvatarId, err)
+		}
+	}
+
+	go SyncSearchFavoriteCounts(added)
+
+	resp.Count()
+
+	return c.Status(http.StatusOK).JSON(resp)
+}
+
+// BulkDeleteAvatarFavorites removes every listed favorite in one transaction, with the same
+// stop_on_error handling as BulkAddAvatarFavorites
+func BulkDeleteAvatarFavorites(c *fiber.Ctx) error {
+	var favorites []models.AvatarFavorite
+
+	r, entries, ok := ParseBulkFavorites(c)
+
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if len(entries) == 0 || len(entries) > MaxBulkFavorites {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidBulkFavorites)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	avatarIds := make([]string, len(entries))
+
+	for i := range entries {
+		avatarIds[i] = entries[i].AvatarId
+	}
+
+	var resp FavoriteBulkResponse
+	var removed []string
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		resp = FavoriteBulkResponse{Results: make([]FavoriteItemResult, 0, len(entries))}
+		removed = make([]string, 0, len(entries))
+
+		if err := tx.Where("user_id = ? AND avatar_id IN ?", userId, avatarIds).Find(&favorites).Error; err != nil {
+			return err
+		}
+
+		byAvatar := make(map[string]uint, len(favorites))
+
+		for _, f := range favorites {
+			byAvatar[f.AvatarId] = f.ID
+		}
+
+		seen := make(map[string]bool, len(entries))
+		ids := make([]uint, 0, len(favorites))
+
+		for _, avatarId := range avatarIds {
+			result := FavoriteItemResult{AvatarId: avatarId}
+
+			if id, ok := byAvatar[avatarId]; seen[avatarId] {
+				result.Status = FavoriteStatusDuplicate
+			} else if !ok {
+				result.Status = FavoriteStatusNotFound
+				result.Error = ErrAvatarNotFound["error"].(string)
+			} else {
+				result.Status = FavoriteStatusRemoved
+				ids = append(ids, id)
+				removed = append(removed, avatarId)
+			}
+
+			seen[avatarId] = true
+			resp.Results = append(resp.Results, result)
+
+			if r.StopOnError && IsFavoriteItemError(&result) {
+				return errBulkStopped
+			}
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := tx.Where("id IN ?", ids).Delete(&models.AvatarFavorite{}).Error; err != nil {
+			return err
+		}
+
+		return AdjustFavoriteCounts(tx, removed, -1)
+	})
+
+	if err == errBulkStopped {
+		resp.RolledBack()
+
+		return c.Status(http.StatusUnprocessableEntity).JSON(resp)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	go SyncSearchFavoriteCounts(removed)
+
+	resp.Count()
+
+	return c.Status(http.StatusOK).JSON(resp)
+}
+
+func (r *FavoriteBulkResponse) Count() {
+	r.Applied, r.Skipped, r.Failed = 0, 0, 0
+
+	for _, result := range r.Results {
+		switch result.Status {
+		case FavoriteStatusAdded, FavoriteStatusRemoved:
+			r.Applied++
+		case FavoriteStatusDuplicate, FavoriteStatusRolledBack:
+			r.Skipped++
+		default:
+			r.Failed++
+		}
+	}
+}
+
+// RolledBack marks the entries that would have been applied once the whole request was rolled back
+func (r *FavoriteBulkResponse) RolledBack() {
+	for i := range r.Results {
+		if r.Results[i].Status == FavoriteStatusAdded || r.Results[i].Status == FavoriteStatusRemoved {
+			r.Results[i].Status = FavoriteStatusRolledBack
+		}
+	}
+
+	r.Count()
+}
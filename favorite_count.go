@@ -0,0 +1,57 @@
+package main
+
+import (
+	"emmApi/models"
+	"gorm.io/gorm"
+)
+
+// AdjustFavoriteCounts moves the favorite count of every listed avatar by delta
+func AdjustFavoriteCounts(tx *gorm.DB, avatarIds []string, delta int) error {
+	if len(avatarIds) == 0 {
+		return nil
+	}
+
+	return tx.Model(&models.Avatar{}).Where("avatar_id IN ?", avatarIds).
+		UpdateColumn("favorite_count", gorm.Expr("GREATEST(favorite_count + ?, 0)", delta)).Error
+}
+
+// RecountFavorites recalculates every avatar's favorite count from scratch, used to backfill
+// the column when it is first added
+func RecountFavorites(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE avatars SET favorite_count = (
+		SELECT COUNT(*) FROM avatar_favorites
+		WHERE avatar_favorites.avatar_id = avatars.avatar_id AND avatar_favorites.deleted_at IS NULL
+	)`).Error
+}
+
+// SyncSearchFavoriteCounts copies the current counts of the listed avatars into their search
+// documents. Avatars that aren't indexed have no document, the write fails for them and is ignored.
+func SyncSearchFavoriteCounts(avatarIds []string) {
+	current, building := GetSearchVersions()
+
+	for start := 0; start < len(avatarIds); start += 1000 {
+		var a []models.Avatar
+
+		end := start + 1000
+
+		if end > len(avatarIds) {
+			end = len(avatarIds)
+		}
+
+		tx := DatabaseConnection.Select("avatar_id_sha256", "favorite_count").Where("avatar_id IN ?", avatarIds[start:end]).Find(&a)
+
+		if tx.Error != nil {
+			return
+		}
+
+		pipe := RedisConnection.Pipeline()
+
+		for _, version := range uniqueVersions(current, building) {
+			for _, avatar := range a {
+				pipe.Do(ctx, "JSON.SET", SearchDocumentKey(version, avatar.AvatarIdSha256), "$.favorite_count", avatar.FavoriteCount)
+			}
+		}
+
+		_, _ = pipe.Exec(ctx)
+	}
+}
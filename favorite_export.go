@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"emmApi/models"
+	"encoding/csv"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FavoriteExportVersion is bumped whenever the export layout changes, version 1 was the bare
+// array of avatar_id and avatar_name which the import still accepts and version 3 added notes and tags
+const FavoriteExportVersion = 3
+
+const (
+	ExportFormatJson   = "json"
+	ExportFormatCsv    = "csv"
+	ExportFormatNdjson = "ndjson"
+)
+
+const MIMEApplicationNdjson = "application/x-ndjson"
+
+var ErrInvalidExportFormat = fiber.Map{"error": "Export format must be json, csv or ndjson."}
+
+var exportFormats = map[string]string{
+	fiber.MIMEApplicationJSON: ExportFormatJson,
+	"text/csv":                ExportFormatCsv,
+	MIMEApplicationNdjson:     ExportFormatNdjson,
+}
+
+// favoriteExportCsvHeader the export header is repeated on every row and the list, list name
+// and tag columns hold JSON arrays, so every row stands on its own
+var favoriteExportCsvHeader = []string{
+	"version",
+	"exported_at",
+	"user_id",
+	"avatar_id",
+	"avatar_name",
+	"avatar_author_id",
+	"avatar_author_name",
+	"avatar_asset_url",
+	"avatar_thumbnail_url",
+	"avatar_public",
+	"avatar_supported_platforms",
+	"position",
+	"list_ids",
+	"list_names",
+	"note",
+	"tags",
+	"favorited_at",
+}
+
+// BuildFavoriteExport collects everything needed to restore the user's favorites. Private
+// avatars the user doesn't own are exported by id only, the same details GetAvatarFavorites
+// withholds stay out of the export.
+func BuildFavoriteExport(userId string) (*FavoriteExport, error) {
+	var favorites []models.AvatarFavorite
+	var lists []models.FavoriteList
+
+	tx := DatabaseConnection.Preload(clause.Associations).Where("user_id = ?", userId).Order(models.FavoriteOrder).Find(&favorites)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	tx = DatabaseConnection.Where("user_id = ?", userId).Order("list_position ASC, created_at ASC").Find(&lists)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	listIds, err := GetFavoriteListIds(favorites)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &FavoriteExport{
+		FavoriteExportHeader: FavoriteExportHeader{
+			Version:    FavoriteExportVersion,
+			ExportedAt: time.Now().UTC(),
+			UserId:     userId,
+			Lists:      lists,
+		},
+		Favorites: make([]FavoriteExportEntry, 0, len(favorites)),
+	}
+
+	for _, f := range favorites {
+		if f.Avatar == nil {
+			continue
+		}
+
+		ids := listIds[f.ID]
+
+		if ids == nil {
+			ids = []string{}
+		}
+
+		avatar := AvatarFavoriteRequest{AvatarId: f.Avatar.AvatarId}
+
+		if len(VisibleFavoriteAvatars(userId, []models.AvatarFavorite{f})) > 0 {
+			avatar = AvatarFavoriteRequest{
+				AvatarId:                 f.Avatar.AvatarId,
+				AvatarName:               f.Avatar.AvatarName,
+				AvatarAuthorId:           f.Avatar.AvatarAuthorId,
+				AvatarAuthorName:         f.Avatar.AvatarAuthorName,
+				AvatarAssetUrl:           f.Avatar.AvatarAssetUrl,
+				AvatarThumbnailUrl:       f.Avatar.AvatarThumbnailUrl,
+				AvatarPublic:             f.Avatar.AvatarPublic,
+				AvatarSupportedPlatforms: f.Avatar.AvatarSupportedPlatforms,
+			}
+		}
+
+		e.Favorites = append(e.Favorites, FavoriteExportEntry{
+			AvatarFavoriteRequest: avatar,
+			Position:              f.Position,
+			ListIds:               ids,
+			Note:                  f.Note,
+			Tags:                  f.Tags,
+			FavoritedAt:           f.CreatedAt,
+		})
+	}
+
+	return e, nil
+}
+
+// GetExportFormat picks the format from the format query parameter, falling back to the
+// Accept header and then to json
+func GetExportFormat(c *fiber.Ctx) (string, bool) {
+	if format := strings.ToLower(c.Query("format")); format != "" {
+		for _, f := range exportFormats {
+			if f == format {
+				return f, true
+			}
+		}
+
+		return "", false
+	}
+
+	if format, ok := exportFormats[c.Accepts(fiber.MIMEApplicationJSON, "text/csv", MIMEApplicationNdjson)]; ok {
+		return format, true
+	}
+
+	return ExportFormatJson, true
+}
+
+// SendFavoriteExport writes the export in the requested format. ndjson starts with the export
+// header on its own line, csv repeats it in the first columns of every row.
+func SendFavoriteExport(c *fiber.Ctx, e *FavoriteExport) error {
+	format, ok := GetExportFormat(c)
+
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidExportFormat)
+	}
+
+	var body []byte
+	var contentType string
+	var err error
+
+	switch format {
+	case ExportFormatCsv:
+		body, err = EncodeFavoriteExportCsv(e)
+		contentType = "text/csv; charset=utf-8"
+	case ExportFormatNdjson:
+		body, err = EncodeFavoriteExportNdjson(e)
+		contentType = MIMEApplicationNdjson
+	default:
+		body, err = sonic.Marshal(e)
+		contentType = fiber.MIMEApplicationJSON
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="favorites-%d.%s"`, e.ExportedAt.Unix(), format))
+
+	return c.Status(http.StatusOK).Send(body)
+}
+
+func EncodeFavoriteExportCsv(e *FavoriteExport) ([]byte, error) {
+	var buf bytes.Buffer
+
+	listNames := make(map[string]string, len(e.Lists))
+
+	for _, l := range e.Lists {
+		listNames[l.ListId] = l.ListName
+	}
+
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(favoriteExportCsvHeader); err != nil {
+		return nil, err
+	}
+
+	for _, f := range e.Favorites {
+		names := make([]string, len(f.ListIds))
+
+		for i, listId := range f.ListIds {
+			names[i] = listNames[listId]
+		}
+
+		listIds, err := sonic.Marshal(f.ListIds)
+
+		if err != nil {
+			return nil, err
+		}
+
+		listNamesJson, err := sonic.Marshal(names)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := sonic.Marshal(f.Tags)
+
+		if err != nil {
+			return nil, err
+		}
+
+		row := []string{
+			strconv.Itoa(e.Version),
+			e.ExportedAt.Format(time.RFC3339),
+			e.UserId,
+			f.AvatarId,
+			f.AvatarName,
+			f.AvatarAuthorId,
+			f.AvatarAuthorName,
+			f.AvatarAssetUrl,
+			f.AvatarThumbnailUrl,
+			strconv.FormatBool(f.AvatarPublic),
+			strconv.Itoa(f.AvatarSupportedPlatforms),
+			strconv.FormatInt(f.Position, 10),
+			string(listIds),
+			string(listNamesJson),
+			f.Note,
+			string(tags),
+			f.FavoritedAt.UTC().Format(time.RFC3339),
+		}
+
+		for i := range row {
+			row[i] = EscapeCsvCell(row[i])
+		}
+
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
+
+// EscapeCsvCell stops spreadsheets from running user supplied text such as avatar names and
+// notes as a formula. Numbers like negative positions are left alone, they can't run anything.
+func EscapeCsvCell(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+
+	return "'" + cell
+}
+
+func EncodeFavoriteExportNdjson(e *FavoriteExport) ([]byte, error) {
+	var buf bytes.Buffer
+
+	header, err := sonic.Marshal(e.FavoriteExportHeader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buf.Write(header)
+	buf.WriteByte('\n')
+
+	for _, f := range e.Favorites {
+		line, err := sonic.Marshal(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"emmApi/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const MaxImportEntries = 5000
+
+var ErrTooManyImportEntries = fiber.Map{"error": fmt.Sprintf("Imports can contain at most %d avatars.", MaxImportEntries)}
+var ErrUnsupportedExportVersion = fiber.Map{"error": "Unsupported export version."}
+
+// ImportFavorites takes either the plain export, which only carries ids and can only import
+// avatars we already know, full avatar entries which also create the avatars we don't, or a
+// versioned export which also restores the user's lists. Every entry is handled behind its own
+// savepoint and gets a result, one bad entry doesn't stop the rest.
+func ImportFavorites(c *fiber.Ctx) error {
+	var entries []AvatarFavoriteRequest
+	var export *FavoriteExport
+
+	if body := bytes.TrimSpace(c.Body()); len(body) > 0 && body[0] == '{' {
+		export = &FavoriteExport{}
+
+		if err := c.BodyParser(export); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+		}
+
+		if export.Version < 2 || export.Version > FavoriteExportVersion {
+			return c.Status(http.StatusBadRequest).JSON(ErrUnsupportedExportVersion)
+		}
+
+		entries = make([]AvatarFavoriteRequest, len(export.Favorites))
+
+		for i := range export.Favorites {
+			entries[i] = export.Favorites[i].AvatarFavoriteRequest
+		}
+	} else if err := c.BodyParser(&entries); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if len(entries) > MaxImportEntries {
+		return c.Status(http.StatusBadRequest).JSON(ErrTooManyImportEntries)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	if errBody, status := CheckFavoriteAccess(userId); errBody != nil {
+		return c.Status(status).JSON(errBody)
+	}
+
+	var r AvatarImportResponse
+	var created []*models.Avatar
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		r.Results, created, err = AddFavoriteEntries(tx, c, entries, models.Import, false)
+
+		return err
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	for _, a := range created {
+		if err := IndexAvatar(a); err != nil {
+			fmt.Printf("Error indexing imported avatar %s: %s\n", a.AvatarId, err)
+		}
+	}
+
+	imported := make([]string, 0, len(entries))
+
+	for _, result := range r.Results {
+		switch result.Status {
+		case FavoriteStatusAdded:
+			r.Imported++
+			imported = append(imported, result.AvatarId)
+		case FavoriteStatusDuplicate:
+			r.Skipped++
+		default:
+			r.Failed++
+		}
+	}
+
+	go SyncSearchFavoriteCounts(imported)
+
+	if export != nil {
+		lists, err := ImportFavoriteLists(userId, export, r.Results)
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		r.ListsCreated = lists
+
+		if err := ImportFavoriteNotes(userId, export, r.Results); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(r)
+}
+
+// ImportFavoriteLists recreates the exported lists, reusing the user's lists with the same
+// name, and puts every favorite that is now in the user's favorites back into its lists.
+// Lists past MaxFavoriteLists are left out.
+func ImportFavoriteLists(userId string, export *FavoriteExport, results []FavoriteItemResult) (int, error) {
+	var existing []models.FavoriteList
+	var favorites []models.AvatarFavorite
+
+	if len(export.Lists) == 0 {
+		return 0, nil
+	}
+
+	tx := DatabaseConnection.Where("user_id = ?", userId).Find(&existing)
+
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	byName := make(map[string]string, len(existing))
+
+	for _, l := range existing {
+		byName[l.ListName] = l.ListId
+	}
+
+	sort.SliceStable(export.Lists, func(i, j int) bool {
+		return export.Lists[i].ListPosition < export.Lists[j].ListPosition
+	})
+
+	created := 0
+	listIds := make(map[string]string, len(export.Lists))
+
+	for _, l := range export.Lists {
+		name, ok := ValidateFavoriteListName(l.ListName)
+
+		if !ok {
+			continue
+		}
+
+		if listId, ok := byName[name]; ok {
+			listIds[l.ListId] = listId
+			continue
+		}
+
+		var list models.FavoriteList
+
+		err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+			var err error
+
+			list, err = CreateFavoriteListIn(tx, userId, name)
+
+			return err
+		})
+
+		if err == errTooManyFavoriteLists {
+			continue
+		} else if err != nil {
+			return created, err
+		}
+
+		byName[name] = list.ListId
+		listIds[l.ListId] = list.ListId
+		created++
+	}
+
+	avatarIds := make([]string, 0, len(results))
+
+	for _, result := range results {
+		if result.Status == FavoriteStatusAdded || result.Status == FavoriteStatusDuplicate {
+			avatarIds = append(avatarIds, result.AvatarId)
+		}
+	}
+
+	if len(avatarIds) == 0 {
+		return created, nil
+	}
+
+	tx = DatabaseConnection.Where("user_id = ? AND avatar_id IN ?", userId, avatarIds).Find(&favorites)
+
+	if tx.Error != nil {
+		return created, tx.Error
+	}
+
+	favoriteIds := make(map[string]uint, len(favorites))
+
+	for _, f := range favorites {
+		favoriteIds[f.AvatarId] = f.ID
+	}
+
+	entries := make([]models.FavoriteListEntry, 0)
+	touched := make([]uint, 0)
+
+	for _, f := range export.Favorites {
+		favoriteId, ok := favoriteIds[f.AvatarId]
+
+		if !ok {
+			continue
+		}
+
+		for _, exportedId := range f.ListIds {
+			if listId, ok := listIds[exportedId]; ok {
+				entries = append(entries, models.FavoriteListEntry{ListId: listId, FavoriteId: favoriteId})
+				touched = append(touched, favoriteId)
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return created, nil
+	}
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(entries, 500).Error; err != nil {
+			return err
+		}
+
+		return TouchFavorites(tx, "id IN ?", touched)
+	})
+
+	return created, err
+}
+
+// ImportFavoriteNotes copies the notes and tags of the favorites this import added, favorites
+// the user already had keep their own
+func ImportFavoriteNotes(userId string, export *FavoriteExport, results []FavoriteItemResult) error {
+	added := make(map[string]bool, len(results))
+
+	for _, result := range results {
+		if result.Status == FavoriteStatusAdded {
+			added[result.AvatarId] = true
+		}
+	}
+
+	for _, f := range export.Favorites {
+		if !added[f.AvatarId] || (f.Note == "" && len(f.Tags) == 0) {
+			continue
+		}
+
+		tags, ok := NormalizeFavoriteTags(f.Tags)
+
+		if !ok {
+			tags = pq.StringArray{}
+		}
+
+		note := []rune(strings.TrimSpace(f.Note))
+
+		if len(note) > MaxFavoriteNoteLength {
+			note = note[:MaxFavoriteNoteLength]
+		}
+
+		tx := DatabaseConnection.Model(&models.AvatarFavorite{}).
+			Where("user_id = ? AND avatar_id = ?", userId, f.AvatarId).
+			Updates(map[string]interface{}{"note": string(note), "tags": tags})
+
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"emmApi/models"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+)
+
+const (
+	MaxFavoriteLists          = 100
+	MaxFavoriteListNameLength = 64
+)
+
+var errInvalidListOrder = errors.New("invalid list order")
+var errTooManyFavoriteLists = errors.New("too many favorite lists")
+
+var ErrFavoriteListNotFound = fiber.Map{"error": "Favorite list not found."}
+var ErrFavoriteNotFound = fiber.Map{"error": "Avatar is not in your favorites."}
+var ErrFavoriteNotInList = fiber.Map{"error": "Avatar is not in this list."}
+var ErrInvalidFavoriteListName = fiber.Map{"error": fmt.Sprintf("List name must be between 1 and %d characters.", MaxFavoriteListNameLength)}
+var ErrTooManyFavoriteLists = fiber.Map{"error": fmt.Sprintf("You can have at most %d favorite lists.", MaxFavoriteLists)}
+var ErrInvalidFavoriteListOrder = fiber.Map{"error": "List order must contain each of your lists exactly once."}
+
+func favoriteListRoutes(router fiber.Router) {
+	router.Get("/avatar/lists", JwtRequired, EnforceModeration, GetFavoriteLists)
+	router.Post("/avatar/lists", JwtRequired, EnforceModeration, CreateFavoriteList)
+	router.Put("/avatar/lists/order", JwtRequired, EnforceModeration, ReorderFavoriteLists)
+	router.Patch("/avatar/lists/:list_id", JwtRequired, EnforceModeration, RenameFavoriteList)
+	router.Delete("/avatar/lists/:list_id", JwtRequired, EnforceModeration, DeleteFavoriteList)
+
+	router.Post("/avatar/lists/:list_id/avatars", JwtRequired, EnforceModeration, AddFavoriteToList)
+	router.Delete("/avatar/lists/:list_id/avatars", JwtRequired, EnforceModeration, RemoveFavoriteFromList)
+}
+
+func GetFavoriteLists(c *fiber.Ctx) error {
+	var lists []models.FavoriteList
+	var counts []struct {
+		ListId string
+		Count  int64
+	}
+
+	userId := c.Locals("userId").(string)
+
+	tx := DatabaseConnection.Where("user_id = ?", userId).Order("list_position ASC, created_at ASC").Find(&lists)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	tx = DatabaseConnection.Model(&models.FavoriteListEntry{}).
+		Select("favorite_list_entries.list_id, COUNT(*) AS count").
+		Joins("JOIN avatar_favorites ON avatar_favorites.id = favorite_list_entries.favorite_id AND avatar_favorites.deleted_at IS NULL").
+		Where("avatar_favorites.user_id = ?", userId).
+		Group("favorite_list_entries.list_id").
+		Scan(&counts)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	favoriteCounts := make(map[string]int64, len(counts))
+
+	for _, count := range counts {
+		favoriteCounts[count.ListId] = count.Count
+	}
+
+	r := make([]FavoriteListResponse, len(lists))
+
+	for i, list := range lists {
+		r[i] = FavoriteListResponse{
+			FavoriteList:  list,
+			FavoriteCount: favoriteCounts[list.ListId],
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(r)
+}
+
+func CreateFavoriteList(c *fiber.Ctx) error {
+	var r FavoriteListRequest
+	var l models.FavoriteList
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	name, ok := ValidateFavoriteListName(r.ListName)
+
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteListName)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		l, err = CreateFavoriteListIn(tx, userId, name)
+
+		return err
+	})
+
+	if err == errTooManyFavoriteLists {
+		return c.Status(http.StatusBadRequest).JSON(ErrTooManyFavoriteLists)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusCreated).JSON(l)
+}
+
+// CreateFavoriteListIn adds a list after the user's last one. The user row stays locked until tx
+// ends, so concurrent creates can't pass the limit or end up on the same position.
+func CreateFavoriteListIn(tx *gorm.DB, userId string, name string) (models.FavoriteList, error) {
+	var count int64
+	var position int
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).First(&models.User{}).Error
+
+	if err != nil {
+		return models.FavoriteList{}, err
+	}
+
+	err = tx.Model(&models.FavoriteList{}).Where("user_id = ?", userId).Count(&count).Error
+
+	if err != nil {
+		return models.FavoriteList{}, err
+	}
+
+	if count >= MaxFavoriteLists {
+		return models.FavoriteList{}, errTooManyFavoriteLists
+	}
+
+	err = tx.Model(&models.FavoriteList{}).Select("COALESCE(MAX(list_position), -1) + 1").Where("user_id = ?", userId).Scan(&position).Error
+
+	if err != nil {
+		return models.FavoriteList{}, err
+	}
+
+	listId, err := GenerateId("lst_")
+
+	if err != nil {
+		return models.FavoriteList{}, err
+	}
+
+	l := models.FavoriteList{
+		ListId:       listId,
+		UserId:       userId,
+		ListName:     name,
+		ListPosition: position,
+	}
+
+	return l, tx.Create(&l).Error
+}
+
+func RenameFavoriteList(c *fiber.Ctx) error {
+	var r FavoriteListRequest
+	var l models.FavoriteList
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	name, ok := ValidateFavoriteListName(r.ListName)
+
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteListName)
+	}
+
+	tx := DatabaseConnection.Where("list_id = ? AND user_id = ?", c.Params("list_id"), c.Locals("userId").(string)).First(&l)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrFavoriteListNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	l.ListName = name
+
+	tx = DatabaseConnection.Save(&l)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(l)
+}
+
+// ReorderFavoriteLists takes the full list order, locking the user's lists so two clients
+// reordering at once can't interleave their positions
+func ReorderFavoriteLists(c *fiber.Ctx) error {
+	var r FavoriteListOrderRequest
+	var lists []models.FavoriteList
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Find(&lists).Error
+
+		if err != nil {
+			return err
+		}
+
+		owned := make(map[string]bool, len(lists))
+
+		for _, l := range lists {
+			owned[l.ListId] = true
+		}
+
+		if len(r.ListIds) != len(lists) {
+			return errInvalidListOrder
+		}
+
+		for position, listId := range r.ListIds {
+			if !owned[listId] {
+				return errInvalidListOrder
+			}
+
+			// a repeated id would otherwise pass, with another list left out
+			delete(owned, listId)
+
+			err := tx.Model(&models.FavoriteList{}).Where("list_id = ?", listId).UpdateColumn("list_position", position).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == errInvalidListOrder {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteListOrder)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return GetFavoriteLists(c)
+}
+
+// DeleteFavoriteList only removes the list, the favorites in it stay in the user's favorites
+func DeleteFavoriteList(c *fiber.Ctx) error {
+	var removed int64
+
+	listId := c.Params("list_id")
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("list_id = ? AND user_id = ?", listId, c.Locals("userId").(string)).Delete(&models.FavoriteList{})
+
+		if res.Error != nil {
+			return res.Error
+		}
+
+		removed = res.RowsAffected
+
+		return TouchFavorites(tx, "id IN (?)", tx.Model(&models.FavoriteListEntry{}).Select("favorite_id").Where("list_id = ?", listId))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if removed == 0 {
+		return c.Status(http.StatusNotFound).JSON(ErrFavoriteListNotFound)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{})
+}
+
+func AddFavoriteToList(c *fiber.Ctx) error {
+	var r FavoriteListEntryRequest
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	l, f, errBody, status := FindListAndFavorite(c, r.AvatarId)
+
+	if errBody != nil {
+		return c.Status(status).JSON(errBody)
+	}
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.FavoriteListEntry{
+			ListId:     l.ListId,
+			FavoriteId: f.ID,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+
+		return TouchFavorites(tx, "id = ?", f.ID)
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{})
+}
+
+func RemoveFavoriteFromList(c *fiber.Ctx) error {
+	var r FavoriteListEntryRequest
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	l, f, errBody, status := FindListAndFavorite(c, r.AvatarId)
+
+	if errBody != nil {
+		return c.Status(status).JSON(errBody)
+	}
+
+	var removed int64
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("list_id = ? AND favorite_id = ?", l.ListId, f.ID).Delete(&models.FavoriteListEntry{})
+
+		if res.Error != nil {
+			return res.Error
+		}
+
+		removed = res.RowsAffected
+
+		if removed == 0 {
+			return nil
+		}
+
+		return TouchFavorites(tx, "id = ?", f.ID)
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if removed == 0 {
+		return c.Status(http.StatusNotFound).JSON(ErrFavoriteNotInList)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{})
+}
+
+// FindListAndFavorite loads the list named in the route and the requester's favorite of avatarId,
+// returning the error body and status to send when either doesn't exist
+func FindListAndFavorite(c *fiber.Ctx, avatarId string) (*models.FavoriteList, *models.AvatarFavorite, fiber.Map, int) {
+	var l models.FavoriteList
+	var f models.AvatarFavorite
+
+	userId := c.Locals("userId").(string)
+
+	tx := DatabaseConnection.Where("list_id = ? AND user_id = ?", c.Params("list_id"), userId).First(&l)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return nil, nil, ErrFavoriteListNotFound, http.StatusNotFound
+	} else if tx.Error != nil {
+		return nil, nil, ErrInternalServerError, http.StatusInternalServerError
+	}
+
+	tx = DatabaseConnection.Where("user_id = ? AND avatar_id = ?", userId, avatarId).First(&f)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return nil, nil, ErrFavoriteNotFound, http.StatusNotFound
+	} else if tx.Error != nil {
+		return nil, nil, ErrInternalServerError, http.StatusInternalServerError
+	}
+
+	return &l, &f, nil, 0
+}
+
+func ValidateFavoriteListName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	length := len([]rune(name))
+
+	return name, length > 0 && length <= MaxFavoriteListNameLength
+}
+
+// GetGroupedFavorites returns the user's visible favorites under each of their lists in order,
+// with the favorites that aren't in any list kept apart
+func GetGroupedFavorites(userId string, favorites []models.AvatarFavorite) (*FavoriteListGroupedResponse, error) {
+	var lists []models.FavoriteList
+	var entries []models.FavoriteListEntry
+
+	tx := DatabaseConnection.Where("user_id = ?", userId).Order("list_position ASC, created_at ASC").Find(&lists)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	listIds := make([]string, len(lists))
+
+	for i, l := range lists {
+		listIds[i] = l.ListId
+	}
+
+	if len(listIds) > 0 {
+		if err := DatabaseConnection.Where("list_id IN ?", listIds).Find(&entries).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	memberOf := make(map[uint][]string, len(entries))
+
+	for _, e := range entries {
+		memberOf[e.FavoriteId] = append(memberOf[e.FavoriteId], e.ListId)
+	}
+
+	grouped := make(map[string][]models.Avatar, len(lists))
+	unlisted := make([]models.AvatarFavorite, 0)
+
+	for _, f := range favorites {
+		if len(memberOf[f.ID]) == 0 {
+			unlisted = append(unlisted, f)
+			continue
+		}
+
+		for _, listId := range memberOf[f.ID] {
+			grouped[listId] = append(grouped[listId], VisibleFavoriteAvatars(userId, []models.AvatarFavorite{f})...)
+		}
+	}
+
+	r := &FavoriteListGroupedResponse{
+		Lists:    make([]FavoriteListAvatars, len(lists)),
+		Unlisted: VisibleFavoriteAvatars(userId, unlisted),
+	}
+
+	for i, l := range lists {
+		r.Lists[i] = FavoriteListAvatars{
+			FavoriteList: l,
+			Avatars:      grouped[l.ListId],
+		}
+
+		if r.Lists[i].Avatars == nil {
+			r.Lists[i].Avatars = []models.Avatar{}
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxFavoriteNoteLength = 500
+	MaxFavoriteTags       = 20
+	MaxFavoriteTagLength  = 32
+)
+
+var ErrInvalidFavoriteNote = fiber.Map{"error": fmt.Sprintf("Notes can be at most %d characters.", MaxFavoriteNoteLength)}
+var ErrInvalidFavoriteTags = fiber.Map{"error": fmt.Sprintf("Favorites can have at most %d tags of up to %d characters.", MaxFavoriteTags, MaxFavoriteTagLength)}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// NormalizeFavoriteTags trims and lowercases the tags and drops empty and repeated ones, so
+// filtering by tag doesn't depend on how it was typed
+func NormalizeFavoriteTags(tags []string) (pq.StringArray, bool) {
+	normalized := make(pq.StringArray, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		if utf8.RuneCountInString(tag) > MaxFavoriteTagLength {
+			return nil, false
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized, len(normalized) <= MaxFavoriteTags
+}
+
+// UpdateAvatarFavorite sets the note and tags of a favorite, fields left out of the request are kept
+func UpdateAvatarFavorite(c *fiber.Ctx) error {
+	var r FavoriteUpdateRequest
+	var fa models.AvatarFavorite
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	updates := make(map[string]interface{})
+
+	if r.Note != nil {
+		note := strings.TrimSpace(*r.Note)
+
+		if utf8.RuneCountInString(note) > MaxFavoriteNoteLength {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteNote)
+		}
+
+		updates["note"] = note
+	}
+
+	if r.Tags != nil {
+		tags, ok := NormalizeFavoriteTags(*r.Tags)
+
+		if !ok {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteTags)
+		}
+
+		updates["tags"] = tags
+	}
+
+	tx := DatabaseConnection.Where("user_id = ? AND avatar_id = ?", c.Locals("userId").(string), r.AvatarId).First(&fa)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrFavoriteNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if len(updates) > 0 {
+		tx = DatabaseConnection.Model(&fa).Updates(updates)
+
+		if tx.Error != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		if note, ok := updates["note"]; ok {
+			fa.Note = note.(string)
+		}
+
+		if tags, ok := updates["tags"]; ok {
+			fa.Tags = tags.(pq.StringArray)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(FavoriteDetailsResponse{
+		AvatarId: fa.AvatarId,
+		Note:     fa.Note,
+		Tags:     fa.Tags,
+	})
+}
+
+// FilterFavorites narrows a favorites query to the given tag and to notes containing the given text
+func FilterFavorites(query *gorm.DB, q *FavoriteListQuery) *gorm.DB {
+	if tag := strings.ToLower(strings.TrimSpace(q.Tag)); tag != "" {
+		query = query.Where("? = ANY(avatar_favorites.tags)", tag)
+	}
+
+	if note := strings.TrimSpace(q.Note); note != "" {
+		query = query.Where(`avatar_favorites.note ILIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(note)+"%")
+	}
+
+	return query
+}
+
+// SetupFavoriteIndexes creates the index tag filters use
+func SetupFavoriteIndexes(db *gorm.DB) {
+	err := db.Exec("CREATE INDEX IF NOT EXISTS idx_avatar_favorites_tags ON avatar_favorites USING GIN (tags)").Error
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"emmApi/models"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultFavoriteLimit        = 1000
+	DefaultVRCPlusFavoriteLimit = 5000
+)
+
+var ErrInvalidFavoriteLimit = fiber.Map{"error": "Favorite limit must be zero or more."}
+
+// FavoriteQuotaError is returned when adding favorites would take the user past their limit
+type FavoriteQuotaError struct {
+	Usage int64
+	Limit int64
+}
+
+func (e *FavoriteQuotaError) Error() string {
+	return fmt.Sprintf("Favorite limit reached, %d of %d favorites used.", e.Usage, e.Limit)
+}
+
+func (e *FavoriteQuotaError) Response() fiber.Map {
+	return fiber.Map{"error": e.Error(), "usage": e.Usage, "limit": e.Limit}
+}
+
+// GetFavoriteLimit returns the admin override when one is set, otherwise the limit of the user's tier
+func GetFavoriteLimit(u *models.User) int64 {
+	if u.FavoriteLimit != nil {
+		return int64(*u.FavoriteLimit)
+	}
+
+	if u.HasVRCPlus {
+		if ServiceConfig.Quota.VRCPlusFavoriteLimit > 0 {
+			return int64(ServiceConfig.Quota.VRCPlusFavoriteLimit)
+		}
+
+		return DefaultVRCPlusFavoriteLimit
+	}
+
+	if ServiceConfig.Quota.DefaultFavoriteLimit > 0 {
+		return int64(ServiceConfig.Quota.DefaultFavoriteLimit)
+	}
+
+	return DefaultFavoriteLimit
+}
+
+func GetFavoriteUsage(tx *gorm.DB, userId string) (int64, error) {
+	var usage int64
+
+	err := tx.Model(&models.AvatarFavorite{}).Where("user_id = ?", userId).Count(&usage).Error
+
+	return usage, err
+}
+
+// EnforceFavoriteQuota must run in the transaction that adds the favorites, the user row stays
+// locked until it ends so concurrent adds can't both squeeze under the limit
+func EnforceFavoriteQuota(tx *gorm.DB, userId string, adding int64) error {
+	u, err := LockFavoriteUser(tx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	usage, err := GetFavoriteUsage(tx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	limit := GetFavoriteLimit(u)
+
+	if usage+adding > limit {
+		return &FavoriteQuotaError{Usage: usage, Limit: limit}
+	}
+
+	return nil
+}
+
+// LockFavoriteUser locks the user row until tx ends, everything that adds favorites or picks
+// their positions takes it first
+func LockFavoriteUser(tx *gorm.DB, userId string) (*models.User, error) {
+	var u models.User
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).First(&u).Error
+
+	return &u, err
+}
+
+// SetFavoriteQuotaHeaders reports the user's usage and limit alongside their favorites
+func SetFavoriteQuotaHeaders(c *fiber.Ctx, userId string) error {
+	var u models.User
+
+	if err := DatabaseConnection.Where("user_id = ?", userId).First(&u).Error; err != nil {
+		return err
+	}
+
+	usage, err := GetFavoriteUsage(DatabaseConnection, userId)
+
+	if err != nil {
+		return err
+	}
+
+	c.Set("X-Favorite-Usage", strconv.FormatInt(usage, 10))
+	c.Set("X-Favorite-Limit", strconv.FormatInt(GetFavoriteLimit(&u), 10))
+
+	return nil
+}
+
+// SendFavoriteError sends the quota error with the user's usage, anything else is an internal error
+func SendFavoriteError(c *fiber.Ctx, err error) error {
+	var quotaErr *FavoriteQuotaError
+
+	if errors.As(err, &quotaErr) {
+		return c.Status(http.StatusForbidden).JSON(quotaErr.Response())
+	}
+
+	return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+}
+
+func SetUserFavoriteLimit(c *fiber.Ctx) error {
+	var r FavoriteLimitRequest
+	var u models.User
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if r.FavoriteLimit != nil && *r.FavoriteLimit < 0 {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteLimit)
+	}
+
+	tx := DatabaseConnection.Where("user_id = ?", r.UserId).First(&u)
+
+	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	} else if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrUserNotFound)
+	}
+
+	before := AuditUserSnapshot(&u)
+	u.FavoriteLimit = r.FavoriteLimit
+
+	tx = DatabaseConnection.Model(&u).Select("FavoriteLimit").Updates(&u)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "user.set_favorite_limit", "user", r.UserId, before, AuditUserSnapshot(&u))
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"favorite_limit": GetFavoriteLimit(&u)})
+}
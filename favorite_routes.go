@@ -4,36 +4,48 @@ import (
 	"crypto/sha256"
 	"emmApi/models"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/RediSearch/redisearch-go/redisearch"
 	"github.com/bytedance/sonic"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var AssetUrlRegex = regexp.MustCompile(`^(https?:\/\/)(api\.vrchat\.cloud|dbinj8iahsbec\.cloudfront\.net)\/(api\/1\/file|avatars)?\/(((file_)([0-9a-fA-F]{8}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{4}\b-[0-9a-fA-F]{12})\/\d\/file)?(.+?\.vrca)?)`)
 
+const MaxFavoriteMoves = 500
+
+var errFavoriteNotFound = errors.New("favorite not found")
+
 var ErrResourceSharingConflict = fiber.Map{"error": "Resource sharing conflict."}
 var ErrAvatarNotFound = fiber.Map{"error": "Avatar not found."}
 var ErrInvalidAssetUrl = fiber.Map{"error": "Invalid asset URL."}
+var ErrInvalidFavoriteMoves = fiber.Map{"error": fmt.Sprintf("Reorder requests must contain between 1 and %d moves.", MaxFavoriteMoves)}
 var ErrVRCPlusRequired = fiber.Map{"error": "VRChat, like emmVRC, relies on the support of their users to keep the platform free. Please support VRChat to unlock these features."}
 
 func favoriteRoutes(router fiber.Router) {
 	router.Get("/avatar", JwtRequired, EnforceModeration, GetAvatarFavorites)
 	router.Post("/avatar", JwtRequired, EnforceModeration, AddAvatarFavorite)
 	router.Delete("/avatar", JwtRequired, EnforceModeration, DeleteAvatarFavorite)
+	router.Patch("/avatar", JwtRequired, EnforceModeration, UpdateAvatarFavorite)
+	router.Post("/avatar/bulk", JwtRequired, EnforceModeration, BulkAddAvatarFavorites)
+	router.Delete("/avatar/bulk", JwtRequired, EnforceModeration, BulkDeleteAvatarFavorites)
+	router.Put("/avatar/order", JwtRequired, EnforceModeration, ReorderAvatarFavorites)
 	router.Get("/avatar/export", JwtRequired, EnforceModeration, ExportFavorites)
+	router.Post("/avatar/import", JwtRequired, EnforceModeration, ImportFavorites)
 
 	router.Put("/avatar", JwtRequired, EnforceModeration, PedestalScan)
 
 	router.Get("/avatar/info/:hash", JwtRequired, EnforceModeration, GetAvatar)
 
 	router.Get("/avatar/search", JwtRequired, EnforceModeration, SearchAvatars)
+	router.Get("/avatar/search/suggest", JwtRequired, EnforceModeration, SuggestAvatarSearch)
 }
 
 func GetAvatar(c *fiber.Ctx) error {
@@ -47,82 +59,176 @@ func GetAvatar(c *fiber.Ctx) error {
 		return c.Status(http.StatusNotFound).JSON(ErrAvatarNotFound)
 	}
 
+	if a.IsShadowed && a.AvatarSubmitterId != c.Locals("userId").(string) {
+		return c.Status(http.StatusNotFound).JSON(ErrAvatarNotFound)
+	}
+
 	return c.Status(http.StatusOK).JSON(a)
 }
 
+// SearchAvatars returns one page of results as a bare array so existing clients keep working,
+// the total hit count is sent in the X-Total-Count header
 func SearchAvatars(c *fiber.Ctx) error {
-	var l []models.LimitedAvatar
+	var s AvatarSearchQuery
 
-	searchTerm := c.Query("q")
-	searchTerm = strings.Replace(searchTerm, "-", "\\-", -1)
+	if err := c.QueryParser(&s); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if queryErr := ValidateSearchQuery(&s); queryErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(queryErr)
+	}
 
-	docs, total, err := RediSearchClient.Search(redisearch.NewQuery(searchTerm).Limit(0, 50000))
+	var cacheKey string
+
+	if !ServiceConfig.Search.DisableCache {
+		// without Redis there is no cache either, the search still runs against the fallback
+		cacheKey, _ = SearchCacheKey(&s)
+	}
+
+	if cacheKey != "" {
+		if body, total, ok := GetCachedSearch(cacheKey); ok {
+			c.Set("X-Total-Count", strconv.Itoa(total))
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			return c.Status(http.StatusOK).Send(body)
+		}
+	}
+
+	l, total, err := SearchWithFailover(&s)
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	if total == 0 {
-		return c.JSON([0]models.LimitedAvatar{})
+	body, err := sonic.Marshal(l)
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	l = make([]models.LimitedAvatar, total)
+	if cacheKey != "" {
+		SetCachedSearch(cacheKey, body, total)
+	}
 
-	for i := 0; i < total; i++ {
-		var a models.LimitedAvatar
-		err := sonic.Unmarshal([]byte(docs[i].Properties["$"].(string)), &a)
+	c.Set("X-Total-Count", strconv.Itoa(total))
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
-		}
+	return c.Status(http.StatusOK).Send(body)
+}
+
+func SuggestAvatarSearch(c *fiber.Ctx) error {
+	var s SearchSuggestQuery
 
-		l[i] = a
+	if err := c.QueryParser(&s); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
 	}
 
-	return c.Status(http.StatusOK).JSON(l)
-}
+	if len([]rune(s.Query)) > MaxSearchTextLength {
+		return c.Status(http.StatusBadRequest).JSON(ErrSearchTooLong)
+	}
 
-func ExportFavorites(c *fiber.Ctx) error {
-	var favorites []models.AvatarFavorite
-	var avatars []models.Avatar
-	var export []AvatarExportResponse
+	if s.Limit < 1 {
+		s.Limit = DefaultSuggestLimit
+	} else if s.Limit > MaxSuggestLimit {
+		s.Limit = MaxSuggestLimit
+	}
+
+	r := SearchSuggestResponse{
+		AvatarNames: make([]SearchSuggestion, 0),
+		AuthorNames: make([]SearchSuggestion, 0),
+	}
 
-	tx := DatabaseConnection.Preload(clause.Associations).Where("user_id = ?", c.Locals("userId").(string)).Order("id DESC").Find(&favorites)
+	prefix := strings.TrimSpace(s.Query)
 
-	if tx.Error != nil {
-		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	if prefix == "" {
+		return c.Status(http.StatusOK).JSON(r)
 	}
 
-	avatars = make([]models.Avatar, len(favorites))
+	var err error
 
-	for i := 0; i < len(favorites); i++ {
-		avatars[i] = *favorites[i].Avatar
+	if r.AvatarNames, err = GetSuggestions("avatar_name", prefix, s.Limit); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	export = make([]AvatarExportResponse, len(avatars))
+	if r.AuthorNames, err = GetSuggestions("avatar_author_name", prefix, s.Limit); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
 
-	for i := 0; i < len(avatars); i++ {
-		export[i] = AvatarExportResponse{
-			AvatarId:   avatars[i].AvatarId,
-			AvatarName: avatars[i].AvatarName,
-		}
+	return c.Status(http.StatusOK).JSON(r)
+}
+
+func ExportFavorites(c *fiber.Ctx) error {
+	e, err := BuildFavoriteExport(c.Locals("userId").(string))
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	return c.JSON(export)
+	return SendFavoriteExport(c, e)
 }
 
+// GetAvatarFavorites returns every favorite by default, list_id narrows it to one list and
+// grouped=true returns all lists with their favorites. Passing limit, cursor or since switches
+// to the paged sync format instead. tag and note filter every format but the paged one. Every
+// format reports the user's quota in the X-Favorite-Usage and X-Favorite-Limit headers.
 func GetAvatarFavorites(c *fiber.Ctx) error {
+	var q FavoriteListQuery
 	var favorites []models.AvatarFavorite
-	//goland:noinspection GoPreferNilSlice
-	var avatars = []models.Avatar{}
 
-	tx := DatabaseConnection.Preload(clause.Associations).Where("user_id = ?", c.Locals("userId").(string)).Order("id DESC").Find(&favorites)
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	if err := SetFavoriteQuotaHeaders(c, userId); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if q.ListId == "" && !q.Grouped && q.Tag == "" && q.Note == "" && (q.Limit != 0 || q.Cursor != "" || q.Since != "") {
+		return GetAvatarFavoritesPage(c, &q)
+	}
+
+	query := FilterFavorites(DatabaseConnection.Preload(clause.Associations).Where("avatar_favorites.user_id = ?", userId), &q)
+
+	if q.ListId != "" {
+		var l models.FavoriteList
+
+		tx := DatabaseConnection.Where("list_id = ? AND user_id = ?", q.ListId, userId).First(&l)
+
+		if tx.Error == gorm.ErrRecordNotFound {
+			return c.Status(http.StatusNotFound).JSON(ErrFavoriteListNotFound)
+		} else if tx.Error != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		query = query.Joins("JOIN favorite_list_entries ON favorite_list_entries.favorite_id = avatar_favorites.id").
+			Where("favorite_list_entries.list_id = ?", l.ListId)
+	}
+
+	tx := query.Order(models.FavoriteOrder).Find(&favorites)
 
 	if tx.Error != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
 
-	userId := c.Locals("userId").(string)
+	if q.Grouped && q.ListId == "" {
+		r, err := GetGroupedFavorites(userId, favorites)
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		}
+
+		return c.JSON(r)
+	}
+
+	return c.JSON(VisibleFavoriteAvatars(userId, favorites))
+}
+
+// VisibleFavoriteAvatars drops private avatars the user doesn't own
+func VisibleFavoriteAvatars(userId string, favorites []models.AvatarFavorite) []models.Avatar {
+	//goland:noinspection GoPreferNilSlice
+	var avatars = []models.Avatar{}
 
 	for i := 0; i < len(favorites); i++ {
 		avatar := *favorites[i].Avatar
@@ -134,7 +240,99 @@ func GetAvatarFavorites(c *fiber.Ctx) error {
 		avatars = append(avatars, avatar)
 	}
 
-	return c.JSON(avatars)
+	return avatars
+}
+
+// NextFavoritePosition places a new favorite ahead of all the user's others, the way new favorites
+// showed first back when favorites were ordered by id
+func NextFavoritePosition(tx *gorm.DB, userId string) (int64, error) {
+	var position int64
+
+	err := tx.Model(&models.AvatarFavorite{}).Select("COALESCE(MIN(position), 0) - 1").Where("user_id = ?", userId).Scan(&position).Error
+
+	return position, err
+}
+
+// ReorderAvatarFavorites applies a batch of moves in order, each one putting an avatar at an index
+// of the user's favorites. The favorites are locked while the moves apply, so concurrent reorders
+// run one after the other rather than mixing their positions.
+func ReorderAvatarFavorites(c *fiber.Ctx) error {
+	var r FavoriteReorderRequest
+	var favorites []models.AvatarFavorite
+
+	if err := c.BodyParser(&r); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	if len(r.Moves) == 0 || len(r.Moves) > MaxFavoriteMoves {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidFavoriteMoves)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Order(models.FavoriteOrder).Find(&favorites).Error
+
+		if err != nil {
+			return err
+		}
+
+		order := make([]*models.AvatarFavorite, len(favorites))
+
+		for i := range favorites {
+			order[i] = &favorites[i]
+		}
+
+		for _, move := range r.Moves {
+			from := -1
+
+			for i, f := range order {
+				if f.AvatarId == move.AvatarId {
+					from = i
+					break
+				}
+			}
+
+			if from == -1 {
+				return errFavoriteNotFound
+			}
+
+			to := move.Position
+
+			if to < 0 {
+				to = 0
+			} else if to > len(order)-1 {
+				to = len(order) - 1
+			}
+
+			moved := order[from]
+			order = append(order[:from], order[from+1:]...)
+			order = append(order[:to], append([]*models.AvatarFavorite{moved}, order[to:]...)...)
+		}
+
+		for i, f := range order {
+			if f.Position == int64(i) {
+				continue
+			}
+
+			err := tx.Model(&models.AvatarFavorite{}).Where("id = ?", f.ID).
+				UpdateColumns(map[string]interface{}{"position": i, "updated_at": time.Now()}).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err == errFavoriteNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrFavoriteNotFound)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return GetAvatarFavorites(c)
 }
 
 func DeleteAvatarFavorite(c *fiber.Ctx) error {
@@ -150,46 +348,65 @@ func DeleteAvatarFavorite(c *fiber.Ctx) error {
 	tx := DatabaseConnection.Where("user_id = ? AND avatar_id = ?", userId, f.AvatarId).First(&a)
 
 	if tx.Error == nil {
-		tx = DatabaseConnection.Delete(&a)
+		err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&a).Error; err != nil {
+				return err
+			}
 
-		if tx.Error != nil {
+			return AdjustFavoriteCounts(tx, []string{a.AvatarId}, -1)
+		})
+
+		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 		}
 	} else {
 		return c.Status(http.StatusNotFound).JSON(ErrAvatarNotFound)
 	}
 
+	SyncSearchFavoriteCounts([]string{a.AvatarId})
+
 	return c.Status(http.StatusOK).JSON(fiber.Map{})
 }
 
-func AddAvatarFavorite(c *fiber.Ctx) error {
-	var f AvatarFavoriteRequest
-	var a models.Avatar
+// CheckFavoriteAccess returns the error to send when the user may not add favorites, queueing a
+// VRChat+ recheck once the last one has expired
+func CheckFavoriteAccess(userId string) (fiber.Map, int) {
 	var u models.User
 
-	if err := c.BodyParser(&f); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
-	}
-
-	userId := c.Locals("userId").(string)
-
 	tx := DatabaseConnection.Where("user_id = ?", userId).First(&u)
 
 	if tx.Error != nil {
-		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+		return ErrInternalServerError, http.StatusInternalServerError
 	}
 
 	if ServiceConfig.CheckService.CheckEnabled {
 		isExpired := IsExpired(&u)
 
 		if !isExpired && !u.HasVRCPlus {
-			return c.Status(http.StatusPaymentRequired).JSON(ErrVRCPlusRequired)
+			return ErrVRCPlusRequired, http.StatusPaymentRequired
 		} else if isExpired {
 			QueueUserCheck(userId)
 		}
 	}
 
-	tx = DatabaseConnection.Where("avatar_id = ?", f.AvatarId).First(&a)
+	return nil, 0
+}
+
+func AddAvatarFavorite(c *fiber.Ctx) error {
+	var f AvatarFavoriteRequest
+	var a models.Avatar
+
+	if err := c.BodyParser(&f); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	userId := c.Locals("userId").(string)
+
+	if errBody, status := CheckFavoriteAccess(userId); errBody != nil {
+		return c.Status(status).JSON(errBody)
+	}
+
+	tx := DatabaseConnection.Where("avatar_id = ?", f.AvatarId).First(&a)
 
 	if tx.Error == gorm.ErrRecordNotFound {
 		if !AssetUrlRegex.MatchString(f.AvatarAssetUrl) {
@@ -202,23 +419,7 @@ func AddAvatarFavorite(c *fiber.Ctx) error {
 			return c.Status(http.StatusBadRequest).JSON(ErrResourceSharingConflict)
 		}
 
-		avatarIdSha := sha256.Sum256([]byte(f.AvatarId))
-		authorIdSha := sha256.Sum256([]byte(f.AvatarAuthorId))
-		shaId := fmt.Sprintf("%s+%s", hex.EncodeToString(avatarIdSha[:]), hex.EncodeToString(authorIdSha[:]))
-		a = models.Avatar{
-			AvatarId:                 f.AvatarId,
-			AvatarIdSha256:           shaId,
-			AvatarName:               f.AvatarName,
-			AvatarAuthorId:           f.AvatarAuthorId,
-			AvatarAuthorName:         f.AvatarAuthorName,
-			AvatarAssetUrl:           f.AvatarAssetUrl,
-			AvatarThumbnailUrl:       f.AvatarThumbnailUrl,
-			AvatarPublic:             f.AvatarPublic,
-			AvatarSupportedPlatforms: f.AvatarSupportedPlatforms,
-			AvatarSource:             models.Favorite,
-			LastValidated:            time.Now(),
-			IsDeleted:                false,
-		}
+		a = NewAvatar(&f, models.Favorite, userId, IsShadowBanned(c))
 
 		tx = DatabaseConnection.Create(&a)
 
@@ -243,11 +444,31 @@ func AddAvatarFavorite(c *fiber.Ctx) error {
 			AvatarId: a.AvatarId,
 		}
 
-		tx = DatabaseConnection.Create(&fa)
+		err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+			if err := EnforceFavoriteQuota(tx, userId, 1); err != nil {
+				return err
+			}
 
-		if tx.Error != nil {
-			return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+			position, err := NextFavoritePosition(tx, userId)
+
+			if err != nil {
+				return err
+			}
+
+			fa.Position = position
+
+			if err := tx.Create(&fa).Error; err != nil {
+				return err
+			}
+
+			return AdjustFavoriteCounts(tx, []string{a.AvatarId}, 1)
+		})
+
+		if err != nil {
+			return SendFavoriteError(c, err)
 		}
+
+		SyncSearchFavoriteCounts([]string{a.AvatarId})
 	} else if tx.Error != nil {
 		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
 	}
@@ -276,23 +497,7 @@ func PedestalScan(c *fiber.Ctx) error {
 			return c.Status(http.StatusBadRequest).JSON(ErrResourceSharingConflict)
 		}
 
-		avatarIdSha := sha256.Sum256([]byte(f.AvatarId))
-		authorIdSha := sha256.Sum256([]byte(f.AvatarAuthorId))
-		shaId := fmt.Sprintf("%s+%s", hex.EncodeToString(avatarIdSha[:]), hex.EncodeToString(authorIdSha[:]))
-		a = models.Avatar{
-			AvatarId:                 f.AvatarId,
-			AvatarIdSha256:           shaId,
-			AvatarName:               f.AvatarName,
-			AvatarAuthorId:           f.AvatarAuthorId,
-			AvatarAuthorName:         f.AvatarAuthorName,
-			AvatarAssetUrl:           f.AvatarAssetUrl,
-			AvatarThumbnailUrl:       f.AvatarThumbnailUrl,
-			AvatarPublic:             f.AvatarPublic,
-			AvatarSupportedPlatforms: f.AvatarSupportedPlatforms,
-			AvatarSource:             models.Pedestal,
-			LastValidated:            time.Now(),
-			IsDeleted:                false,
-		}
+		a = NewAvatar(&f, models.Pedestal, c.Locals("userId").(string), IsShadowBanned(c))
 
 		tx = DatabaseConnection.Create(&a)
 
@@ -310,21 +515,47 @@ func PedestalScan(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(fiber.Map{})
 }
 
+// NewAvatar builds the row for an avatar seen for the first time
+func NewAvatar(f *AvatarFavoriteRequest, source models.AvatarSource, submitterId string, shadowed bool) models.Avatar {
+	avatarIdSha := sha256.Sum256([]byte(f.AvatarId))
+	authorIdSha := sha256.Sum256([]byte(f.AvatarAuthorId))
+	shaId := fmt.Sprintf("%s+%s", hex.EncodeToString(avatarIdSha[:]), hex.EncodeToString(authorIdSha[:]))
+
+	return models.Avatar{
+		AvatarId:                 f.AvatarId,
+		AvatarIdSha256:           shaId,
+		AvatarName:               f.AvatarName,
+		AvatarAuthorId:           f.AvatarAuthorId,
+		AvatarAuthorName:         f.AvatarAuthorName,
+		AvatarAssetUrl:           f.AvatarAssetUrl,
+		AvatarThumbnailUrl:       f.AvatarThumbnailUrl,
+		AvatarPublic:             f.AvatarPublic,
+		AvatarSupportedPlatforms: f.AvatarSupportedPlatforms,
+		AvatarSource:             source,
+		AvatarSubmitterId:        submitterId,
+		IsShadowed:               shadowed,
+		LastValidated:            time.Now(),
+		IsDeleted:                false,
+	}
+}
+
 func IndexAvatar(a *models.Avatar) error {
 	var b models.BlacklistedAuthor
 
+	if !a.AvatarPublic || a.IsShadowed {
+		return nil
+	}
+
 	tx := DatabaseConnection.Where("user_id = ?", a.AvatarAuthorId).First(&b)
 
 	if tx.Error == gorm.ErrRecordNotFound {
-		res, err := ReJsonClient.JSONSet(a.AvatarIdSha256, "$", a.GetLimitedAvatar())
-
-		if err != nil {
+		if err := SetSearchDocument(a.GetLimitedAvatar()); err != nil {
 			return err
 		}
 
-		if res.(string) != "OK" {
-			fmt.Printf("Error adding avatar to search index: %s\n", a.AvatarIdSha256)
-		}
+		InvalidateSearchCache()
+
+		return AddSuggestions(a)
 	}
 
 	return nil
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"emmApi/models"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultFavoritePageLimit = 100
+	MaxFavoritePageLimit     = 500
+	// syncTokenOverlap moves each sync token back a little so favorites written by requests that
+	// were still in flight when the token was issued are sent again rather than missed
+	syncTokenOverlap = 5 * time.Second
+)
+
+var errInvalidFavoriteCursor = errors.New("invalid favorite cursor")
+
+var ErrInvalidSyncToken = fiber.Map{"error": "Invalid sync token or cursor."}
+var ErrSyncTokenExpired = fiber.Map{"error": "Sync token is too old to include every removal, please do a full sync."}
+
+// favoriteCursor carries everything needed to continue a paged listing, so every page of one
+// sync uses the same since and hands back the same sync token
+type favoriteCursor struct {
+	Since   int64
+	Token   int64
+	AfterId uint
+}
+
+func (f *favoriteCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%d", f.Since, f.Token, f.AfterId)))
+}
+
+func DecodeFavoriteCursor(cursor string) (*favoriteCursor, error) {
+	var f favoriteCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return nil, errInvalidFavoriteCursor
+	}
+
+	if _, err := fmt.Sscanf(string(raw), "%d:%d:%d", &f.Since, &f.Token, &f.AfterId); err != nil {
+		return nil, errInvalidFavoriteCursor
+	}
+
+	return &f, nil
+}
+
+func EncodeSyncToken(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d", t.UnixNano())))
+}
+
+func DecodeSyncToken(token string) (int64, error) {
+	var nanos int64
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+
+	if err != nil {
+		return 0, errInvalidFavoriteCursor
+	}
+
+	if _, err := fmt.Sscanf(string(raw), "%d", &nanos); err != nil {
+		return 0, errInvalidFavoriteCursor
+	}
+
+	return nanos, nil
+}
+
+// GetAvatarFavoritesPage pages through the user's favorites by id. Without since every live
+// favorite is returned, with since only favorites added or changed after it, plus tombstones
+// for the ones removed. Clients apply removed before favorites and keep requesting with
+// next_cursor until it is empty, then store sync_token as the next since.
+func GetAvatarFavoritesPage(c *fiber.Ctx, q *FavoriteListQuery) error {
+	var favorites []models.AvatarFavorite
+	var cursor *favoriteCursor
+
+	userId := c.Locals("userId").(string)
+
+	if q.Cursor != "" {
+		var err error
+
+		if cursor, err = DecodeFavoriteCursor(q.Cursor); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(ErrInvalidSyncToken)
+		}
+	} else {
+		cursor = &favoriteCursor{Token: time.Now().Add(-syncTokenOverlap).UnixNano()}
+
+		if q.Since != "" {
+			since, err := DecodeSyncToken(q.Since)
+
+			if err != nil {
+				return c.Status(http.StatusBadRequest).JSON(ErrInvalidSyncToken)
+			}
+
+			cursor.Since = since
+		}
+	}
+
+	// tombstones older than the retention window have been purged, a delta from before then would miss removals
+	if cursor.Since != 0 && time.Unix(0, cursor.Since).Before(time.Now().Add(-GetDeletedRetention())) {
+		return c.Status(http.StatusGone).JSON(ErrSyncTokenExpired)
+	}
+
+	limit := q.Limit
+
+	if limit < 1 {
+		limit = DefaultFavoritePageLimit
+	} else if limit > MaxFavoritePageLimit {
+		limit = MaxFavoritePageLimit
+	}
+
+	query := DatabaseConnection.Preload(clause.Associations).Where("user_id = ? AND id > ?", userId, cursor.AfterId)
+
+	if cursor.Since != 0 {
+		since := time.Unix(0, cursor.Since)
+		query = query.Unscoped().Where("(updated_at > ? OR deleted_at > ?)", since, since)
+	}
+
+	tx := query.Order("id ASC").Limit(limit).Find(&favorites)
+
+	if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	listIds, err := GetFavoriteListIds(favorites)
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	r := FavoritePageResponse{
+		Favorites: make([]FavoriteSyncItem, 0, len(favorites)),
+		Removed:   make([]FavoriteTombstone, 0),
+		SyncToken: EncodeSyncToken(time.Unix(0, cursor.Token)),
+	}
+
+	for _, f := range favorites {
+		if f.DeletedAt.Valid {
+			r.Removed = append(r.Removed, FavoriteTombstone{
+				FavoriteId: f.ID,
+				AvatarId:   f.AvatarId,
+				RemovedAt:  f.DeletedAt.Time,
+			})
+			continue
+		}
+
+		visible := VisibleFavoriteAvatars(userId, []models.AvatarFavorite{f})
+
+		if len(visible) == 0 {
+			continue
+		}
+
+		ids := listIds[f.ID]
+
+		if ids == nil {
+			ids = []string{}
+		}
+
+		r.Favorites = append(r.Favorites, FavoriteSyncItem{
+			FavoriteId:  f.ID,
+			Position:    f.Position,
+			ListIds:     ids,
+			Note:        f.Note,
+			Tags:        f.Tags,
+			FavoritedAt: f.CreatedAt,
+			Avatar:      visible[0],
+		})
+	}
+
+	if len(favorites) == limit {
+		cursor.AfterId = favorites[len(favorites)-1].ID
+		r.NextCursor = cursor.Encode()
+	}
+
+	return c.Status(http.StatusOK).JSON(r)
+}
+
+// GetFavoriteListIds maps each favorite to the lists it is in
+func GetFavoriteListIds(favorites []models.AvatarFavorite) (map[uint][]string, error) {
+	var entries []models.FavoriteListEntry
+
+	listIds := make(map[uint][]string)
+
+	if len(favorites) == 0 {
+		return listIds, nil
+	}
+
+	ids := make([]uint, len(favorites))
+
+	for i, f := range favorites {
+		ids[i] = f.ID
+	}
+
+	tx := DatabaseConnection.
+		Joins("JOIN favorite_lists ON favorite_lists.list_id = favorite_list_entries.list_id AND favorite_lists.deleted_at IS NULL").
+		Where("favorite_list_entries.favorite_id IN ?", ids).
+		Find(&entries)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	for _, e := range entries {
+		listIds[e.FavoriteId] = append(listIds[e.FavoriteId], e.ListId)
+	}
+
+	return listIds, nil
+}
+
+// TouchFavorites marks the matching favorites as changed so the next delta sync sends them again
+func TouchFavorites(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	return tx.Model(&models.AvatarFavorite{}).Where(query, args...).UpdateColumn("updated_at", time.Now()).Error
+}
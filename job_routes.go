@@ -0,0 +1,99 @@
+package main
+
+import (
+	"emmApi/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+var ErrJobNotFound = fiber.Map{"error": "Job not found."}
+var ErrJobAlreadyFinished = fiber.Map{"error": "Job has already finished."}
+
+func jobRoutes(router fiber.Router) {
+	router.Get("/admin/jobs", RequireAdmin(models.RoleViewer), ListJobs)
+	router.Get("/admin/jobs/:job_id", RequireAdmin(models.RoleViewer), GetJob)
+	router.Post("/admin/jobs/:job_id/cancel", RequireAdmin(models.RoleModerator), CancelJobRequest)
+}
+
+func ListJobs(c *fiber.Ctx) error {
+	var q JobListQuery
+	var jobs []models.Job
+	var total int64
+
+	if err := c.QueryParser(&q); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(ErrInvalidRequestBody)
+	}
+
+	q.Page, q.Limit = ClampPagination(q.Page, q.Limit)
+	tx := DatabaseConnection.Model(&models.Job{})
+
+	if q.Type != "" {
+		tx = tx.Where("job_type = ?", q.Type)
+	}
+
+	if q.State != "" {
+		tx = tx.Where("job_state = ?", q.State)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	err := tx.Order("created_at DESC").Offset((q.Page - 1) * q.Limit).Limit(q.Limit).Find(&jobs).Error
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	res := JobListResponse{
+		Jobs:  make([]models.JobResponse, len(jobs)),
+		Page:  q.Page,
+		Limit: q.Limit,
+		Total: total,
+	}
+
+	for i := range jobs {
+		res.Jobs[i] = *jobs[i].GetJobResponse()
+	}
+
+	return c.Status(http.StatusOK).JSON(res)
+}
+
+func GetJob(c *fiber.Ctx) error {
+	var j models.Job
+
+	tx := DatabaseConnection.Where("job_id = ?", c.Params("job_id")).First(&j)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrJobNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).JSON(j.GetJobResponse())
+}
+
+func CancelJobRequest(c *fiber.Ctx) error {
+	var j models.Job
+
+	tx := DatabaseConnection.Where("job_id = ?", c.Params("job_id")).First(&j)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(ErrJobNotFound)
+	} else if tx.Error != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	if j.JobState.IsFinished() {
+		return c.Status(http.StatusConflict).JSON(ErrJobAlreadyFinished)
+	}
+
+	if err := CancelJob(&j); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(ErrInternalServerError)
+	}
+
+	RecordAudit(c, "job.cancel", "job", j.JobId, nil, j.GetJobResponse())
+
+	return c.Status(http.StatusOK).JSON(j.GetJobResponse())
+}
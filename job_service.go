@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"emmApi/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	jobPollInterval      = 5 * time.Second
+	jobHeartbeatInterval = 5 * time.Second
+	jobOrphanTimeout     = 2 * time.Minute
+	jobMaxAttempts       = 3
+	jobWorkersPerProcess = 2
+)
+
+var ErrJobCancelled = errors.New("job cancelled")
+var ErrJobAlreadyActive = errors.New("a job of this type is already queued or running")
+
+// JobHandler does the work for one job type. It should report progress through the JobContext
+// and stop as soon as Progress returns an error.
+type JobHandler func(j *JobContext) error
+
+type JobContext struct {
+	Job     *models.Job
+	Context context.Context
+}
+
+var (
+	jobHandlers = map[string]JobHandler{}
+	jobSlots    = make(chan struct{}, jobWorkersPerProcess)
+	jobWorkerId = fmt.Sprintf("%s-%d", hostname(), os.Getpid())
+	jobMutex    = &sync.Mutex{}
+)
+
+func hostname() string {
+	name, err := os.Hostname()
+
+	if err != nil {
+		return "unknown"
+	}
+
+	return name
+}
+
+func RegisterJobType(jobType string, handler JobHandler) {
+	jobMutex.Lock()
+	jobHandlers[jobType] = handler
+	jobMutex.Unlock()
+}
+
+// EnqueueJob persists a queued job, a worker in any process picks it up on its next poll
+func EnqueueJob(jobType string, payload interface{}, createdBy string) (*models.Job, error) {
+	jobId, err := GenerateId("job_")
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	j := models.Job{
+		JobId:     jobId,
+		JobType:   jobType,
+		JobState:  models.JobQueued,
+		Payload:   string(data),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	if err := DatabaseConnection.Create(&j).Error; err != nil {
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// EnqueueUniqueJob refuses to queue a job while another of the same type is still active
+func EnqueueUniqueJob(jobType string, payload interface{}, createdBy string) (*models.Job, error) {
+	active, err := GetActiveJob(jobType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if active != nil {
+		return active, ErrJobAlreadyActive
+	}
+
+	return EnqueueJob(jobType, payload, createdBy)
+}
+
+func GetActiveJob(jobType string) (*models.Job, error) {
+	var j models.Job
+
+	tx := DatabaseConnection.Where("job_type = ? AND job_state IN ?", jobType, []models.JobState{models.JobQueued, models.JobRunning}).
+		Order("created_at DESC").First(&j)
+
+	if tx.Error == gorm.ErrRecordNotFound {
+		return nil, nil
+	} else if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	return &j, nil
+}
+
+// Progress records how far the job has got and reports whether it should stop
+func (j *JobContext) Progress(done int64, total int64) error {
+	j.Job.Progress = done
+	j.Job.Total = total
+
+	DatabaseConnection.Model(&models.Job{}).Where("job_id = ?", j.Job.JobId).
+		UpdateColumns(map[string]interface{}{"progress": done, "total": total})
+
+	if j.Context.Err() != nil {
+		return ErrJobCancelled
+	}
+
+	return nil
+}
+
+func (j *JobContext) DecodePayload(v interface{}) error {
+	return json.Unmarshal([]byte(j.Job.Payload), v)
+}
+
+// SetResult stores the job's output, e.g. a report, so it can be read back once the job finishes
+func (j *JobContext) SetResult(v interface{}) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	result := string(data)
+	j.Job.Result = &result
+
+	return DatabaseConnection.Model(&models.Job{}).Where("job_id = ?", j.Job.JobId).UpdateColumn("result", result).Error
+}
+
+func InitJobService() {
+	ticker := time.NewTicker(jobPollInterval)
+	go func() {
+		for range ticker.C {
+			RequeueOrphanedJobs()
+
+			for ClaimAndRunJob() {
+			}
+		}
+	}()
+}
+
+// RequeueOrphanedJobs picks up jobs whose worker stopped heartbeating, usually after a restart
+func RequeueOrphanedJobs() {
+	cutoff := time.Now().Add(-jobOrphanTimeout)
+
+	DatabaseConnection.Model(&models.Job{}).
+		Where("job_state = ? AND heartbeat_at < ? AND attempts >= ?", models.JobRunning, cutoff, jobMaxAttempts).
+		UpdateColumns(map[string]interface{}{
+			"job_state":   models.JobFailed,
+			"error":       "worker stopped responding too many times",
+			"finished_at": time.Now(),
+		})
+
+	DatabaseConnection.Model(&models.Job{}).
+		Where("job_state = ? AND heartbeat_at < ?", models.JobRunning, cutoff).
+		UpdateColumns(map[string]interface{}{"job_state": models.JobQueued, "worker_id": ""})
+}
+
+// ClaimAndRunJob starts the oldest queued job if this process has a free slot, returning
+// false once there is nothing left to start
+func ClaimAndRunJob() bool {
+	select {
+	case jobSlots <- struct{}{}:
+	default:
+		return false
+	}
+
+	var j models.Job
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("job_state = ?", models.JobQueued).
+			Order("created_at ASC").
+			First(&j).Error
+
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		j.JobState = models.JobRunning
+		j.WorkerId = jobWorkerId
+		j.Attempts++
+		j.HeartbeatAt = &now
+
+		if j.StartedAt == nil {
+			j.StartedAt = &now
+		}
+
+		return tx.Save(&j).Error
+	})
+
+	if err != nil {
+		<-jobSlots
+
+		if err != gorm.ErrRecordNotFound {
+			fmt.Printf("Error claiming job: %s\n", err)
+		}
+
+		return false
+	}
+
+	go func() {
+		defer func() { <-jobSlots }()
+		RunJob(&j)
+	}()
+
+	return true
+}
+
+func RunJob(j *models.Job) {
+	jobMutex.Lock()
+	handler, ok := jobHandlers[j.JobType]
+	jobMutex.Unlock()
+
+	if !ok {
+		FinishJob(j, fmt.Errorf("unknown job type %s", j.JobType))
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go JobHeartbeat(j.JobId, cancel, done)
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+
+		return handler(&JobContext{Job: j, Context: jobCtx})
+	}()
+
+	FinishJob(j, err)
+}
+
+// JobHeartbeat keeps the job marked as alive and cancels it once an admin asks it to stop
+func JobHeartbeat(jobId string, cancel context.CancelFunc, done chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			var j models.Job
+
+			DatabaseConnection.Model(&models.Job{}).Where("job_id = ?", jobId).UpdateColumn("heartbeat_at", time.Now())
+			tx := DatabaseConnection.Select("cancel_requested").Where("job_id = ?", jobId).First(&j)
+
+			if tx.Error == nil && j.CancelRequested {
+				cancel()
+			}
+		}
+	}
+}
+
+func FinishJob(j *models.Job, err error) {
+	now := time.Now()
+	j.FinishedAt = &now
+
+	switch {
+	case err == nil:
+		j.JobState = models.JobSucceeded
+	case errors.Is(err, ErrJobCancelled):
+		j.JobState = models.JobCancelled
+	default:
+		j.JobState = models.JobFailed
+		j.Error = err.Error()
+		fmt.Printf("Job %s (%s) failed: %s\n", j.JobId, j.JobType, err)
+	}
+
+	tx := DatabaseConnection.Model(&models.Job{}).Where("job_id = ?", j.JobId).
+		UpdateColumns(map[string]interface{}{
+			"job_state":   j.JobState,
+			"error":       j.Error,
+			"progress":    j.Progress,
+			"total":       j.Total,
+			"finished_at": now,
+		})
+
+	if tx.Error != nil {
+		fmt.Printf("Error finishing job %s: %s\n", j.JobId, tx.Error)
+	}
+}
+
+// CancelJob stops a queued job straight away, running jobs are flagged and stop at their next heartbeat
+func CancelJob(j *models.Job) error {
+	if j.JobState == models.JobQueued {
+		now := time.Now()
+		tx := DatabaseConnection.Model(&models.Job{}).
+			Where("job_id = ? AND job_state = ?", j.JobId, models.JobQueued).
+			UpdateColumns(map[string]interface{}{"job_state": models.JobCancelled, "finished_at": now})
+
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		if tx.RowsAffected > 0 {
+			j.JobState = models.JobCancelled
+			j.FinishedAt = &now
+			return nil
+		}
+	}
+
+	j.CancelRequested = true
+	return DatabaseConnection.Model(&models.Job{}).Where("job_id = ?", j.JobId).UpdateColumn("cancel_requested", true).Error
+}
@@ -10,7 +10,8 @@ import (
 	"os"
 )
 
-func init() {
+// loadConfig runs from main rather than init so tests can build the package without a config file
+func loadConfig() {
 	open, err := os.Open("service_conf.json")
 	if err != nil {
 		log.Printf("failed to open config: %s", err)
@@ -43,6 +44,7 @@ func writeDefaultConfig() {
 }
 
 func main() {
+	loadConfig()
 	SetupDatabaseConnection()
 	SetupRedisConnection()
 
@@ -58,9 +60,20 @@ func main() {
 	appGroup := app.Group("/api/v2")
 	authRoutes(appGroup)
 	favoriteRoutes(appGroup)
+	favoriteListRoutes(appGroup)
 	adminRoutes(appGroup)
+	adminAccountRoutes(appGroup)
+	banRoutes(appGroup)
+	appealRoutes(appGroup)
+	auditRoutes(appGroup)
+	jobRoutes(appGroup)
 
 	InitCheckService()
+	InitPurgeService()
+
+	RegisterAdminJobs()
+	InitJobService()
+	InitReconcileService()
 
 	log.Fatal(app.Listen(":3002"))
 }
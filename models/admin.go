@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+type AdminRole string
+
+const (
+	RoleViewer     AdminRole = "viewer"
+	RoleModerator  AdminRole = "moderator"
+	RoleSuperAdmin AdminRole = "superadmin"
+)
+
+// Level roles are strictly ordered, each one can do everything the ones below it can
+func (r AdminRole) Level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleModerator:
+		return 2
+	case RoleSuperAdmin:
+		return 3
+	}
+
+	return 0
+}
+
+func (r AdminRole) IsValid() bool {
+	return r.Level() > 0
+}
+
+type AdminPrincipal struct {
+	AdminId    string    `gorm:"primaryKey" json:"admin_id"`
+	AdminName  string    `gorm:"uniqueIndex" json:"admin_name"`
+	AdminRole  AdminRole `json:"admin_role"`
+	IsDisabled bool      `json:"is_disabled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type AdminApiKey struct {
+	ID        uint            `gorm:"primaryKey" json:"key_id"`
+	AdminId   string          `gorm:"index" json:"admin_id"`
+	Admin     *AdminPrincipal `gorm:"foreignKey:AdminId;references:AdminId" json:"-"`
+	KeyHash   string          `gorm:"uniqueIndex" json:"-"`
+	KeyPrefix string          `json:"key_prefix"`
+	KeyLabel  string          `json:"key_label"`
+	LastUsed  time.Time       `json:"last_used"`
+	RevokedAt *time.Time      `json:"revoked_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
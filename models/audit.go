@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+var ErrAuditLogImmutable = errors.New("audit log entries cannot be modified")
+
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ActorId    string    `gorm:"index" json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	Action     string    `gorm:"index" json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetId   string    `gorm:"index" json:"target_id"`
+	Payload    string    `gorm:"type:jsonb" json:"-"`
+	SourceIp   string    `json:"source_ip"`
+	Before     string    `gorm:"type:jsonb" json:"-"`
+	After      string    `gorm:"type:jsonb" json:"-"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+type AuditEntry struct {
+	ID         uint            `json:"id"`
+	ActorId    string          `json:"actor_id"`
+	ActorName  string          `json:"actor_name"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetId   string          `json:"target_id"`
+	Payload    json.RawMessage `json:"payload"`
+	SourceIp   string          `json:"source_ip"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// BeforeUpdate the audit log is append-only
+func (a *AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+func (a *AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+// GetAuditEntry the snapshot columns already hold JSON, so they are embedded as-is rather than re-encoded
+func (a *AuditLog) GetAuditEntry() *AuditEntry {
+	return &AuditEntry{
+		ID:         a.ID,
+		ActorId:    a.ActorId,
+		ActorName:  a.ActorName,
+		Action:     a.Action,
+		TargetType: a.TargetType,
+		TargetId:   a.TargetId,
+		Payload:    json.RawMessage(a.Payload),
+		SourceIp:   a.SourceIp,
+		Before:     json.RawMessage(a.Before),
+		After:      json.RawMessage(a.After),
+		CreatedAt:  a.CreatedAt,
+	}
+}
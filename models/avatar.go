@@ -15,8 +15,12 @@ type Avatar struct {
 	AvatarPublic             bool         `json:"avatar_public"`
 	AvatarSupportedPlatforms int          `json:"avatar_supported_platforms"`
 	AvatarSource             AvatarSource `json:"-"`
+	AvatarSubmitterId        string       `json:"-" gorm:"index"`
+	IsShadowed               bool         `json:"-" gorm:"not null;default:false"`
 	LastValidated            time.Time    `json:"-"`
 	IsDeleted                bool         `json:"-"`
+	FavoriteCount            int64        `json:"favorite_count" gorm:"not null;default:0;index"`
+	CreatedAt                time.Time    `json:"-" gorm:"index"`
 }
 
 type AvatarSource int32
@@ -35,6 +39,8 @@ type LimitedAvatar struct {
 	AvatarThumbnailUrl       string `json:"avatar_thumbnail_url"`
 	AvatarPublic             bool   `json:"avatar_public"`
 	AvatarSupportedPlatforms int    `json:"avatar_supported_platforms"`
+	AvatarCreated            int64  `json:"avatar_created"`
+	FavoriteCount            int64  `json:"favorite_count"`
 }
 
 func (a *Avatar) GetLimitedAvatar() *LimitedAvatar {
@@ -46,5 +52,7 @@ func (a *Avatar) GetLimitedAvatar() *LimitedAvatar {
 		AvatarThumbnailUrl:       a.AvatarThumbnailUrl,
 		AvatarPublic:             a.AvatarPublic,
 		AvatarSupportedPlatforms: a.AvatarSupportedPlatforms,
+		AvatarCreated:            a.CreatedAt.Unix(),
+		FavoriteCount:            a.FavoriteCount,
 	}
 }
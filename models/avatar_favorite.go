@@ -1,18 +1,29 @@
 package models
 
 import (
+	"github.com/lib/pq"
+	"gorm.io/gorm"
 	"time"
 )
 
 type AvatarFavorite struct {
-	ID        uint    `gorm:"primaryKey"`
-	UserId    string  `gorm:"index" gorm:"foreignKey:UserId"`
-	User      *User   `gorm:"references:UserId"`
-	AvatarId  string  `gorm:"foreignKey:AvatarId"`
-	Avatar    *Avatar `gorm:"references:AvatarId"`
+	ID        uint           `gorm:"primaryKey"`
+	UserId    string         `gorm:"index" gorm:"foreignKey:UserId"`
+	User      *User          `gorm:"references:UserId"`
+	AvatarId  string         `gorm:"foreignKey:AvatarId"`
+	Avatar    *Avatar        `gorm:"references:AvatarId"`
+	Position  int64          `gorm:"not null;default:0"`
+	Note      string         `gorm:"not null;default:''"`
+	Tags      pq.StringArray `gorm:"type:text[] NOT NULL;default: '{}'::text[]"`
 	CreatedAt time.Time
+	UpdatedAt time.Time      `gorm:"index"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// FavoriteOrder is the order favorites are listed and exported in, the id breaks ties between
+// favorites that were never reordered
+const FavoriteOrder = "avatar_favorites.position ASC, avatar_favorites.id DESC"
+
 type BlacklistedAuthor struct {
 	UserId string `gorm:"primaryKey"`
 }
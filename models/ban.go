@@ -8,7 +8,54 @@ type Ban struct {
 	BanIssuer  string    `json:"-" gorm:"index"`
 	BanReason  string    `json:"ban_reason"`
 	IpAddress  string    `json:"-"`
+	IpRange    *string   `json:"-" gorm:"type:cidr;index"`
+	IsShadow   bool      `json:"-"`
 	BanExpires time.Time `json:"ban_expires"`
 	BanUpdated time.Time `json:"-"`
 	BanCreated time.Time `json:"-"`
 }
+
+type AdminBan struct {
+	BanId      string    `json:"ban_id"`
+	BanUserId  string    `json:"ban_user_id"`
+	BanIssuer  string    `json:"ban_issuer"`
+	BanReason  string    `json:"ban_reason"`
+	IpAddress  string    `json:"ip_address"`
+	IpRange    string    `json:"ip_range"`
+	IsShadow   bool      `json:"is_shadow"`
+	BanExpires time.Time `json:"ban_expires"`
+	BanUpdated time.Time `json:"ban_updated"`
+	BanCreated time.Time `json:"ban_created"`
+	IsActive   bool      `json:"is_active"`
+}
+
+// IsPermanent a zero expiry means the ban never lifts on its own
+func (b *Ban) IsPermanent() bool {
+	return b.BanExpires.Unix() <= time.UnixMilli(0).Unix()
+}
+
+func (b *Ban) IsActive() bool {
+	return b.IsPermanent() || b.BanExpires.Unix() >= time.Now().Unix()
+}
+
+func (b *Ban) GetAdminBan() *AdminBan {
+	ipRange := ""
+
+	if b.IpRange != nil {
+		ipRange = *b.IpRange
+	}
+
+	return &AdminBan{
+		BanId:      b.BanId,
+		BanUserId:  b.BanUserId,
+		BanIssuer:  b.BanIssuer,
+		BanReason:  b.BanReason,
+		IpAddress:  b.IpAddress,
+		IpRange:    ipRange,
+		IsShadow:   b.IsShadow,
+		BanExpires: b.BanExpires,
+		BanUpdated: b.BanUpdated,
+		BanCreated: b.BanCreated,
+		IsActive:   b.IsActive(),
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+type AppealStatus string
+
+const (
+	AppealPending  AppealStatus = "pending"
+	AppealAccepted AppealStatus = "accepted"
+	AppealRejected AppealStatus = "rejected"
+)
+
+type BanAppeal struct {
+	AppealId       string       `gorm:"primaryKey" json:"appeal_id"`
+	BanId          string       `gorm:"uniqueIndex" json:"ban_id"`
+	Ban            *Ban         `gorm:"foreignKey:BanId;references:BanId" json:"-"`
+	AppealMessage  string       `json:"appeal_message"`
+	AppealStatus   AppealStatus `gorm:"index" json:"appeal_status"`
+	AppealResponse string       `json:"appeal_response"`
+	AppealReviewer string       `json:"-"`
+	AppealCreated  time.Time    `json:"appeal_created"`
+	AppealUpdated  time.Time    `json:"appeal_updated"`
+}
+
+type AdminBanAppeal struct {
+	AppealId       string       `json:"appeal_id"`
+	BanId          string       `json:"ban_id"`
+	Ban            *AdminBan    `json:"ban,omitempty"`
+	AppealMessage  string       `json:"appeal_message"`
+	AppealStatus   AppealStatus `json:"appeal_status"`
+	AppealResponse string       `json:"appeal_response"`
+	AppealReviewer string       `json:"appeal_reviewer"`
+	AppealCreated  time.Time    `json:"appeal_created"`
+	AppealUpdated  time.Time    `json:"appeal_updated"`
+}
+
+func (a *BanAppeal) GetAdminBanAppeal() *AdminBanAppeal {
+	adminAppeal := &AdminBanAppeal{
+		AppealId:       a.AppealId,
+		BanId:          a.BanId,
+		AppealMessage:  a.AppealMessage,
+		AppealStatus:   a.AppealStatus,
+		AppealResponse: a.AppealResponse,
+		AppealReviewer: a.AppealReviewer,
+		AppealCreated:  a.AppealCreated,
+		AppealUpdated:  a.AppealUpdated,
+	}
+
+	if a.Ban != nil {
+		adminAppeal.Ban = a.Ban.GetAdminBan()
+	}
+
+	return adminAppeal
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type FavoriteList struct {
+	ListId       string         `gorm:"primaryKey" json:"list_id"`
+	UserId       string         `gorm:"index" json:"-"`
+	ListName     string         `json:"list_name"`
+	ListPosition int            `json:"list_position"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// FavoriteListEntry puts a favorite in a list, a favorite can be in any number of a user's lists
+type FavoriteListEntry struct {
+	ListId     string `gorm:"primaryKey"`
+	FavoriteId uint   `gorm:"primaryKey;index"`
+	CreatedAt  time.Time
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+func (s JobState) IsFinished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+type Job struct {
+	JobId           string   `gorm:"primaryKey"`
+	JobType         string   `gorm:"index"`
+	JobState        JobState `gorm:"index"`
+	Payload         string   `gorm:"type:jsonb"`
+	Result          *string  `gorm:"type:jsonb"`
+	Progress        int64
+	Total           int64
+	Error           string
+	Attempts        int
+	CancelRequested bool
+	WorkerId        string
+	CreatedBy       string
+	CreatedAt       time.Time `gorm:"index"`
+	StartedAt       *time.Time
+	FinishedAt      *time.Time
+	HeartbeatAt     *time.Time
+}
+
+type JobResponse struct {
+	JobId           string          `json:"job_id"`
+	JobType         string          `json:"job_type"`
+	JobState        JobState        `json:"job_state"`
+	Payload         json.RawMessage `json:"payload"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Progress        int64           `json:"progress"`
+	Total           int64           `json:"total"`
+	Error           string          `json:"error"`
+	Attempts        int             `json:"attempts"`
+	CancelRequested bool            `json:"cancel_requested"`
+	CreatedBy       string          `json:"created_by"`
+	CreatedAt       time.Time       `json:"created_at"`
+	StartedAt       *time.Time      `json:"started_at"`
+	FinishedAt      *time.Time      `json:"finished_at"`
+}
+
+func (j *Job) GetJobResponse() *JobResponse {
+	r := &JobResponse{
+		JobId:           j.JobId,
+		JobType:         j.JobType,
+		JobState:        j.JobState,
+		Payload:         json.RawMessage(j.Payload),
+		Progress:        j.Progress,
+		Total:           j.Total,
+		Error:           j.Error,
+		Attempts:        j.Attempts,
+		CancelRequested: j.CancelRequested,
+		CreatedBy:       j.CreatedBy,
+		CreatedAt:       j.CreatedAt,
+		StartedAt:       j.StartedAt,
+		FinishedAt:      j.FinishedAt,
+	}
+
+	if j.Result != nil {
+		r.Result = json.RawMessage(*j.Result)
+	}
+
+	return r
+}
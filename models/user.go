@@ -2,6 +2,7 @@ package models
 
 import (
 	"github.com/lib/pq"
+	"gorm.io/gorm"
 	"time"
 )
 
@@ -10,13 +11,16 @@ type User struct {
 	UserPin          string
 	UserKnownAliases pq.StringArray `gorm:"type:text[] NOT NULL;default: '{}'::text[]"`
 	HasVRCPlus       bool
+	FavoriteLimit    *int
 	LastVRCPlusCheck time.Time
 	LastSeen         time.Time
 	CreatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
 }
 
 type PersistentToken struct {
-	ID     uint   `gorm:"primaryKey"`
-	UserId string `gorm:"index" gorm:"foreignKey:UserId"`
-	Token  string `gorm:"index"`
+	ID        uint           `gorm:"primaryKey"`
+	UserId    string         `gorm:"index" gorm:"foreignKey:UserId"`
+	Token     string         `gorm:"index"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
@@ -3,14 +3,26 @@ package main
 import (
 	"emmApi/models"
 	"github.com/gofiber/fiber/v2"
+	"net"
 	"net/http"
 	"time"
 )
 
+type BanMatch struct {
+	Ban          *models.Ban
+	MatchedOn    string
+	MatchedRange string
+}
+
 func EnforceModeration(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
 	ban := GetBan(userId, c.IP())
 
+	if ban != nil && ban.IsShadow {
+		c.Locals("shadowBanned", true)
+		return c.Next()
+	}
+
 	if ban != nil {
 		return c.Status(http.StatusForbidden).JSON(ban)
 	}
@@ -18,17 +30,112 @@ func EnforceModeration(c *fiber.Ctx) error {
 	return c.Next()
 }
 
+// IsShadowBanned shadow banned users get normal responses but their submissions are hidden from everyone else
+func IsShadowBanned(c *fiber.Ctx) bool {
+	shadowBanned, ok := c.Locals("shadowBanned").(bool)
+	return ok && shadowBanned
+}
+
 func GetBan(userId string, ipAddress string) *models.Ban {
-	var ban models.Ban
-	err := DatabaseConnection.Where("ban_user_id = ? OR ip_address = ?", userId, ipAddress).First(&ban).Error
+	match := FindBan(userId, ipAddress)
+
+	if match == nil {
+		return nil
+	}
+
+	return match.Ban
+}
+
+// FindBan looks up every active ban matching the user ID, exact IP address or an IP range
+// containing the address and returns the one that takes precedence
+func FindBan(userId string, ipAddress string) *BanMatch {
+	var bans []models.Ban
+
+	// empty identifiers are skipped so they can't match bans that only target the other one
+	target := DatabaseConnection.Where("1 = 0")
+
+	if userId != "" {
+		target = target.Or("ban_user_id = ?", userId)
+	}
+
+	if ipAddress != "" {
+		target = target.Or("ip_address = ?", ipAddress)
+	}
+
+	if net.ParseIP(ipAddress) != nil {
+		target = target.Or("ip_range >>= ?::inet", ipAddress)
+	}
+
+	err := DatabaseConnection.Where(target).
+		Where("ban_expires <= ? OR ban_expires >= ?", time.UnixMilli(0), time.Now()).
+		Find(&bans).Error
 
 	if err != nil {
 		return nil
 	}
 
-	if ban.BanExpires.Unix() > time.UnixMilli(0).Unix() && ban.BanExpires.Unix() < time.Now().Unix() {
+	ban := SelectBan(bans)
+
+	if ban == nil {
 		return nil
 	}
 
-	return &ban
+	return GetBanMatch(ban, userId, ipAddress)
+}
+
+// SelectBan picks the ban that applies out of several matches: a hard ban beats a shadow ban,
+// a permanent ban beats a temporary one, otherwise the latest expiry wins. Expired bans are ignored.
+func SelectBan(bans []models.Ban) *models.Ban {
+	var selected *models.Ban
+
+	for i := range bans {
+		ban := &bans[i]
+
+		if !ban.IsActive() {
+			continue
+		}
+
+		if selected == nil {
+			selected = ban
+			continue
+		}
+
+		if ban.IsShadow != selected.IsShadow {
+			if selected.IsShadow {
+				selected = ban
+			}
+
+			continue
+		}
+
+		if selected.IsPermanent() {
+			if ban.IsPermanent() && ban.BanCreated.After(selected.BanCreated) {
+				selected = ban
+			}
+
+			continue
+		}
+
+		if ban.IsPermanent() || ban.BanExpires.After(selected.BanExpires) {
+			selected = ban
+		}
+	}
+
+	return selected
+}
+
+func GetBanMatch(ban *models.Ban, userId string, ipAddress string) *BanMatch {
+	match := &BanMatch{Ban: ban}
+
+	switch {
+	case userId != "" && ban.BanUserId == userId:
+		match.MatchedOn = "user_id"
+	case ipAddress != "" && ban.IpAddress == ipAddress:
+		match.MatchedOn = "ip_address"
+	case ban.IpRange != nil:
+		match.MatchedOn = "ip_range"
+		match.MatchedRange = *ban.IpRange
+	}
+
+	return match
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"emmApi/models"
+	"testing"
+	"time"
+)
+
+func TestSelectBan(t *testing.T) {
+	now := time.Now()
+	permanent := time.UnixMilli(0)
+
+	ban := func(id string, shadow bool, expires time.Time, created time.Time) models.Ban {
+		return models.Ban{BanId: id, IsShadow: shadow, BanExpires: expires, BanCreated: created}
+	}
+
+	tests := []struct {
+		name string
+		bans []models.Ban
+		want string
+	}{
+		{"no bans", nil, ""},
+		{"only expired", []models.Ban{ban("a", false, now.Add(-time.Hour), now)}, ""},
+		{"single active", []models.Ban{ban("a", false, now.Add(time.Hour), now)}, "a"},
+		{
+			"hard beats shadow",
+			[]models.Ban{ban("shadow", true, permanent, now), ban("hard", false, now.Add(time.Hour), now)},
+			"hard",
+		},
+		{
+			"hard beats shadow in either order",
+			[]models.Ban{ban("hard", false, now.Add(time.Hour), now), ban("shadow", true, permanent, now)},
+			"hard",
+		},
+		{
+			"permanent beats temporary",
+			[]models.Ban{ban("temp", false, now.Add(24*time.Hour), now), ban("perm", false, permanent, now)},
+			"perm",
+		},
+		{
+			"permanent kept over later temporary",
+			[]models.Ban{ban("perm", false, permanent, now), ban("temp", false, now.Add(24*time.Hour), now)},
+			"perm",
+		},
+		{
+			"latest expiry wins",
+			[]models.Ban{ban("soon", false, now.Add(time.Hour), now), ban("later", false, now.Add(48*time.Hour), now)},
+			"later",
+		},
+		{
+			"newest permanent wins",
+			[]models.Ban{ban("old", false, permanent, now.Add(-time.Hour)), ban("new", false, permanent, now)},
+			"new",
+		},
+		{
+			"expired hard ban ignored",
+			[]models.Ban{ban("hard", false, now.Add(-time.Hour), now), ban("shadow", true, now.Add(time.Hour), now)},
+			"shadow",
+		},
+	}
+
+	for _, tt := range tests {
+		got := SelectBan(tt.bans)
+
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("%s: SelectBan() = %s, want nil", tt.name, got.BanId)
+			}
+
+			continue
+		}
+
+		if got == nil || got.BanId != tt.want {
+			t.Errorf("%s: SelectBan() = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+const DefaultDeletedRetentionDays = 30
+
+func GetDeletedRetention() time.Duration {
+	days := ServiceConfig.Retention.DeletedRetentionDays
+
+	if days <= 0 {
+		days = DefaultDeletedRetentionDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func InitPurgeService() {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		PurgeExpiredTombstones()
+
+		for range ticker.C {
+			PurgeExpiredTombstones()
+		}
+	}()
+}
+
+// PurgeExpiredTombstones hard deletes soft deleted rows once they fall outside the retention window
+func PurgeExpiredTombstones() {
+	cutoff := time.Now().Add(-GetDeletedRetention())
+
+	for _, model := range []interface{}{&models.AvatarFavorite{}, &models.FavoriteList{}, &models.PersistentToken{}, &models.User{}} {
+		tx := DatabaseConnection.Unscoped().Where("deleted_at < ?", cutoff).Delete(model)
+
+		if tx.Error != nil {
+			fmt.Printf("Error purging deleted records: %s\n", tx.Error)
+			continue
+		}
+
+		if tx.RowsAffected > 0 {
+			fmt.Printf("Purged %d deleted records from %s\n", tx.RowsAffected, tx.Statement.Table)
+		}
+	}
+
+	// list entries go once either their list or their favorite has been purged
+	tx := DatabaseConnection.
+		Where("list_id NOT IN (?) OR favorite_id NOT IN (?)",
+			DatabaseConnection.Unscoped().Model(&models.FavoriteList{}).Select("list_id"),
+			DatabaseConnection.Unscoped().Model(&models.AvatarFavorite{}).Select("id")).
+		Delete(&models.FavoriteListEntry{})
+
+	if tx.Error != nil {
+		fmt.Printf("Error purging favorite list entries: %s\n", tx.Error)
+	}
+}
+
+// SoftDeleteUser tombstones a user with their favorites and tokens under one timestamp so
+// RestoreUser can bring back exactly what was removed together
+func SoftDeleteUser(userId string) error {
+	var avatarIds []string
+
+	now := time.Now()
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.AvatarFavorite{}).Where("user_id = ?", userId).Pluck("avatar_id", &avatarIds).Error
+
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&models.AvatarFavorite{}, &models.FavoriteList{}, &models.PersistentToken{}, &models.User{}} {
+			err := tx.Model(model).Where("user_id = ?", userId).UpdateColumn("deleted_at", now).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return AdjustFavoriteCounts(tx, avatarIds, -1)
+	})
+
+	if err == nil {
+		go SyncSearchFavoriteCounts(avatarIds)
+	}
+
+	return err
+}
+
+func RestoreUser(u *models.User) error {
+	var avatarIds []string
+
+	deletedAt := u.DeletedAt.Time
+
+	err := DatabaseConnection.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&models.AvatarFavorite{}).
+			Where("user_id = ? AND deleted_at = ?", u.UserId, deletedAt).
+			Pluck("avatar_id", &avatarIds).Error
+
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&models.AvatarFavorite{}, &models.FavoriteList{}, &models.PersistentToken{}, &models.User{}} {
+			err := tx.Unscoped().Model(model).
+				Where("user_id = ? AND deleted_at = ?", u.UserId, deletedAt).
+				UpdateColumn("deleted_at", nil).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := TouchFavorites(tx, "user_id = ? AND avatar_id IN ?", u.UserId, avatarIds); err != nil {
+			return err
+		}
+
+		return AdjustFavoriteCounts(tx, avatarIds, 1)
+	})
+
+	if err == nil {
+		go SyncSearchFavoriteCounts(avatarIds)
+	}
+
+	return err
+}
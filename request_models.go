@@ -1,6 +1,10 @@
 package main
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"emmApi/models"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
 
 var ErrInvalidRequestBody = fiber.Map{"error": "Invalid request body."}
 var ErrInternalServerError = fiber.Map{"error": "Internal server error."}
@@ -35,18 +39,350 @@ type AvatarFavoriteRequest struct {
 	AvatarSupportedPlatforms int    `json:"avatar_supported_platforms"`
 }
 
-type AvatarExportResponse struct {
-	AvatarId   string `json:"avatar_id"`
-	AvatarName string `json:"avatar_name"`
+type FavoriteMove struct {
+	AvatarId string `json:"avatar_id"`
+	Position int    `json:"position"`
+}
+
+type FavoriteReorderRequest struct {
+	Moves []FavoriteMove `json:"moves"`
+}
+
+type FavoriteListQuery struct {
+	ListId  string `query:"list_id"`
+	Grouped bool   `query:"grouped"`
+	Since   string `query:"since"`
+	Cursor  string `query:"cursor"`
+	Limit   int    `query:"limit"`
+	Tag     string `query:"tag"`
+	Note    string `query:"note"`
+}
+
+// FavoriteUpdateRequest changes the note and tags of a favorite, null fields are left as they are
+type FavoriteUpdateRequest struct {
+	AvatarId string    `json:"avatar_id"`
+	Note     *string   `json:"note"`
+	Tags     *[]string `json:"tags"`
+}
+
+type FavoriteDetailsResponse struct {
+	AvatarId string   `json:"avatar_id"`
+	Note     string   `json:"note"`
+	Tags     []string `json:"tags"`
+}
+
+type FavoriteSyncItem struct {
+	FavoriteId  uint          `json:"favorite_id"`
+	Position    int64         `json:"position"`
+	ListIds     []string      `json:"list_ids"`
+	Note        string        `json:"note"`
+	Tags        []string      `json:"tags"`
+	FavoritedAt time.Time     `json:"favorited_at"`
+	Avatar      models.Avatar `json:"avatar"`
+}
+
+type FavoriteTombstone struct {
+	FavoriteId uint      `json:"favorite_id"`
+	AvatarId   string    `json:"avatar_id"`
+	RemovedAt  time.Time `json:"removed_at"`
+}
+
+type FavoritePageResponse struct {
+	Favorites  []FavoriteSyncItem  `json:"favorites"`
+	Removed    []FavoriteTombstone `json:"removed"`
+	NextCursor string              `json:"next_cursor"`
+	SyncToken  string              `json:"sync_token"`
+}
+
+type FavoriteListRequest struct {
+	ListName string `json:"list_name"`
+}
+
+type FavoriteListOrderRequest struct {
+	ListIds []string `json:"list_ids"`
+}
+
+type FavoriteListEntryRequest struct {
+	AvatarId string `json:"avatar_id"`
+}
+
+type FavoriteListResponse struct {
+	models.FavoriteList
+	FavoriteCount int64 `json:"favorite_count"`
+}
+
+type FavoriteListAvatars struct {
+	models.FavoriteList
+	Avatars []models.Avatar `json:"avatars"`
+}
+
+type FavoriteListGroupedResponse struct {
+	Lists    []FavoriteListAvatars `json:"lists"`
+	Unlisted []models.Avatar       `json:"unlisted"`
+}
+
+type FavoriteExportHeader struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exported_at"`
+	UserId     string                `json:"user_id"`
+	Lists      []models.FavoriteList `json:"lists"`
+}
+
+type FavoriteExportEntry struct {
+	AvatarFavoriteRequest
+	Position    int64     `json:"position"`
+	ListIds     []string  `json:"list_ids"`
+	Note        string    `json:"note"`
+	Tags        []string  `json:"tags"`
+	FavoritedAt time.Time `json:"favorited_at"`
+}
+
+type FavoriteExport struct {
+	FavoriteExportHeader
+	Favorites []FavoriteExportEntry `json:"favorites"`
+}
+
+type FavoriteItemResult struct {
+	AvatarId string `json:"avatar_id"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FavoriteBulkRequest takes full avatar entries, bare avatar IDs or both
+type FavoriteBulkRequest struct {
+	Avatars     []AvatarFavoriteRequest `json:"avatars"`
+	AvatarIds   []string                `json:"avatar_ids"`
+	StopOnError bool                    `json:"stop_on_error"`
+}
+
+type FavoriteBulkResponse struct {
+	Applied int                  `json:"applied"`
+	Skipped int                  `json:"skipped"`
+	Failed  int                  `json:"failed"`
+	Results []FavoriteItemResult `json:"results"`
+}
+
+type AvatarImportResponse struct {
+	Imported     int                  `json:"imported"`
+	Skipped      int                  `json:"skipped"`
+	Failed       int                  `json:"failed"`
+	ListsCreated int                  `json:"lists_created"`
+	Results      []FavoriteItemResult `json:"results"`
 }
 
 // Admin Request Models
 
+type PageQuery struct {
+	Page  int `query:"page"`
+	Limit int `query:"limit"`
+}
+
 type GenericUserRequest struct {
 	UserId string `json:"user_id"`
 }
 
+// FavoriteLimitRequest sets a per user favorite limit, a null limit goes back to the tier limit
+type FavoriteLimitRequest struct {
+	UserId        string `json:"user_id"`
+	FavoriteLimit *int   `json:"favorite_limit"`
+}
+
 type TransferRequest struct {
 	UserId       string `json:"user_id"`
 	TargetUserId string `json:"target_user_id"`
 }
+
+type BanCreateRequest struct {
+	UserId     string     `json:"user_id"`
+	IpAddress  string     `json:"ip_address"`
+	IpRange    string     `json:"ip_range"`
+	BanReason  string     `json:"ban_reason"`
+	BanExpires *time.Time `json:"ban_expires"`
+	IsShadow   bool       `json:"is_shadow"`
+}
+
+type BanUpdateRequest struct {
+	BanReason  *string    `json:"ban_reason"`
+	BanExpires *time.Time `json:"ban_expires"`
+	Permanent  bool       `json:"permanent"`
+	IsShadow   *bool      `json:"is_shadow"`
+}
+
+type BanListQuery struct {
+	UserId string `query:"user_id"`
+	Status string `query:"status"`
+	Page   int    `query:"page"`
+	Limit  int    `query:"limit"`
+}
+
+type BanListResponse struct {
+	Bans  []models.AdminBan `json:"bans"`
+	Page  int               `json:"page"`
+	Limit int               `json:"limit"`
+	Total int64             `json:"total"`
+}
+
+type BanLookupQuery struct {
+	UserId    string `query:"user_id"`
+	IpAddress string `query:"ip_address"`
+}
+
+type BanLookupResponse struct {
+	Ban          *models.AdminBan `json:"ban"`
+	MatchedOn    string           `json:"matched_on"`
+	MatchedRange string           `json:"matched_range,omitempty"`
+}
+
+type BanAppealRequest struct {
+	BanId         string `json:"ban_id"`
+	AppealMessage string `json:"appeal_message"`
+}
+
+type BanAppealReviewRequest struct {
+	AppealResponse string `json:"appeal_response"`
+}
+
+type BanAppealListQuery struct {
+	Status string `query:"status"`
+	Page   int    `query:"page"`
+	Limit  int    `query:"limit"`
+}
+
+type BanAppealListResponse struct {
+	Appeals []models.AdminBanAppeal `json:"appeals"`
+	Page    int                     `json:"page"`
+	Limit   int                     `json:"limit"`
+	Total   int64                   `json:"total"`
+}
+
+type AdminAccountRequest struct {
+	AdminName string           `json:"admin_name"`
+	AdminRole models.AdminRole `json:"admin_role"`
+}
+
+type AdminAccountResponse struct {
+	models.AdminPrincipal
+	Key string `json:"key"`
+}
+
+type AdminAccountUpdateRequest struct {
+	AdminRole  *models.AdminRole `json:"admin_role"`
+	IsDisabled *bool             `json:"is_disabled"`
+}
+
+type AdminApiKeyRequest struct {
+	KeyLabel string `json:"key_label"`
+}
+
+type AdminApiKeyResponse struct {
+	models.AdminApiKey
+	Key string `json:"key"`
+}
+
+type AuditListQuery struct {
+	Actor  string `query:"actor"`
+	Target string `query:"target"`
+	Action string `query:"action"`
+	From   string `query:"from"`
+	To     string `query:"to"`
+	Page   int    `query:"page"`
+	Limit  int    `query:"limit"`
+}
+
+type AuditListResponse struct {
+	Entries []models.AuditEntry `json:"entries"`
+	Page    int                 `json:"page"`
+	Limit   int                 `json:"limit"`
+	Total   int64               `json:"total"`
+}
+
+type RestoreFavoritesRequest struct {
+	UserId    string    `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type DeletedFavoriteGroup struct {
+	DeletedAt     time.Time `json:"deleted_at"`
+	FavoriteCount int64     `json:"favorite_count"`
+}
+
+type DeletedUserResponse struct {
+	UserId           string    `json:"user_id"`
+	UserKnownAliases []string  `json:"user_known_aliases"`
+	DeletedAt        time.Time `json:"deleted_at"`
+	PurgeAt          time.Time `json:"purge_at"`
+	FavoriteCount    int64     `json:"favorite_count"`
+}
+
+type DeletedUserListResponse struct {
+	Users []DeletedUserResponse `json:"users"`
+	Page  int                   `json:"page"`
+	Limit int                   `json:"limit"`
+	Total int64                 `json:"total"`
+}
+
+type JobListQuery struct {
+	Type  string `query:"type"`
+	State string `query:"state"`
+	Page  int    `query:"page"`
+	Limit int    `query:"limit"`
+}
+
+type JobListResponse struct {
+	Jobs  []models.JobResponse `json:"jobs"`
+	Page  int                  `json:"page"`
+	Limit int                  `json:"limit"`
+	Total int64                `json:"total"`
+}
+
+type AvatarSearchQuery struct {
+	Query     string `query:"q"`
+	AuthorId  string `query:"author_id"`
+	Platforms int    `query:"platforms"`
+	Public    string `query:"public"`
+	Sort      string `query:"sort"`
+	Order     string `query:"order"`
+	Offset    int    `query:"offset"`
+	Limit     int    `query:"limit"`
+}
+
+type SearchReconcileRequest struct {
+	Repair bool `json:"repair"`
+}
+
+type SearchReconcileReport struct {
+	Version    string   `json:"version"`
+	Checked    int64    `json:"checked"`
+	Missing    int64    `json:"missing"`
+	Stale      int64    `json:"stale"`
+	Unexpected int64    `json:"unexpected"`
+	Repaired   bool     `json:"repaired"`
+	MissingIds []string `json:"missing_ids"`
+	StaleIds   []string `json:"stale_ids"`
+	// UnexpectedIds are documents for private, shadowed, blacklisted or unknown avatars
+	UnexpectedIds []string `json:"unexpected_ids"`
+}
+
+type SearchCacheStats struct {
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRatio   float64 `json:"hit_ratio"`
+	Generation int64   `json:"generation"`
+	TtlSeconds int     `json:"ttl_seconds"`
+}
+
+type SearchSuggestQuery struct {
+	Query string `query:"q"`
+	Limit int    `query:"limit"`
+}
+
+type SearchSuggestion struct {
+	Text     string  `json:"text"`
+	Score    float64 `json:"score"`
+	AuthorId string  `json:"author_id,omitempty"`
+}
+
+type SearchSuggestResponse struct {
+	AvatarNames []SearchSuggestion `json:"avatar_names"`
+	AuthorNames []SearchSuggestion `json:"author_names"`
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"gorm.io/gorm"
+	"strings"
+	"unicode"
+)
+
+const (
+	SearchBackendRediSearch = "redisearch"
+	SearchBackendPostgres   = "postgres"
+)
+
+// SearchBackend runs an already validated avatar search, returning one page of results and the
+// total number of hits
+type SearchBackend interface {
+	Name() string
+	Search(s *AvatarSearchQuery) ([]models.LimitedAvatar, int, error)
+}
+
+type RediSearchBackend struct{}
+
+type PostgresSearchBackend struct{}
+
+func (RediSearchBackend) Name() string {
+	return SearchBackendRediSearch
+}
+
+func (RediSearchBackend) Search(s *AvatarSearchQuery) ([]models.LimitedAvatar, int, error) {
+	docs, total, err := RediSearchClient.Search(BuildSearchQuery(s))
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	l := make([]models.LimitedAvatar, len(docs))
+
+	for i := range docs {
+		raw, ok := docs[i].Properties["$"].(string)
+
+		if !ok {
+			return nil, 0, fmt.Errorf("search document %s has no body", docs[i].Id)
+		}
+
+		if err := sonic.Unmarshal([]byte(raw), &l[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return l, total, nil
+}
+
+func (PostgresSearchBackend) Name() string {
+	return SearchBackendPostgres
+}
+
+// Search applies the same rules as the RediSearch index: only public, unshadowed avatars from
+// authors who aren't blacklisted are returned
+func (PostgresSearchBackend) Search(s *AvatarSearchQuery) ([]models.LimitedAvatar, int, error) {
+	var a []models.Avatar
+	var total int64
+
+	l := make([]models.LimitedAvatar, 0)
+
+	// the index only ever holds public avatars, so asking for private ones finds nothing
+	if s.Public == "false" {
+		return l, 0, nil
+	}
+
+	tokens, queryErr := tokenizeSearchText(s.Query)
+
+	if queryErr != nil {
+		return nil, 0, fmt.Errorf("invalid search query: %v", queryErr["error"])
+	}
+
+	query := SearchableAvatars()
+
+	for _, t := range tokens {
+		query = query.Where(PostgresSearchCondition(t))
+	}
+
+	if s.AuthorId != "" {
+		query = query.Where("avatar_author_id = ?", s.AuthorId)
+	}
+
+	if s.Platforms > 0 {
+		query = query.Where("avatar_supported_platforms & ? = ?", s.Platforms, s.Platforms)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	direction := "DESC"
+
+	if SearchSortAscending(s) {
+		direction = "ASC"
+	}
+
+	// relevance ranking only exists in RediSearch, name order stands in for it here
+	switch s.Sort {
+	case "recent":
+		// avatars from before created_at was recorded are indexed with the zero time, which
+		// RediSearch sorts as the oldest, so the NULLs go wherever the oldest avatars go
+		if direction == "DESC" {
+			query = query.Order("created_at DESC NULLS LAST")
+		} else {
+			query = query.Order("created_at ASC NULLS FIRST")
+		}
+	case "name":
+		query = query.Order("avatar_name " + direction)
+	case "popular":
+		query = query.Order("favorite_count " + direction)
+	default:
+		query = query.Order("avatar_name ASC")
+	}
+
+	tx := query.Offset(s.Offset).Limit(s.Limit).Find(&a)
+
+	if tx.Error != nil {
+		return nil, 0, tx.Error
+	}
+
+	for i := range a {
+		l = append(l, *a[i].GetLimitedAvatar())
+	}
+
+	return l, int(total), nil
+}
+
+// SearchableAvatars selects the avatars that belong in the search index
+func SearchableAvatars() *gorm.DB {
+	return DatabaseConnection.Model(&models.Avatar{}).
+		Where("avatar_public = ? AND is_shadowed IS NOT TRUE", true).
+		Where("avatar_author_id NOT IN (?)", DatabaseConnection.Model(&models.BlacklistedAuthor{}).Select("user_id"))
+}
+
+// PostgresSearchCondition matches one search term against the tsvector expression indexes,
+// terms without a field match either the avatar or the author name
+func PostgresSearchCondition(t searchToken) *gorm.DB {
+	var tsquery string
+	value := t.value
+	prefix := ""
+
+	if t.prefix {
+		prefix = PrefixTsquery(value)
+	}
+
+	switch {
+	case t.phrase:
+		tsquery = "phraseto_tsquery('simple', ?)"
+	case prefix != "":
+		tsquery = "to_tsquery('simple', ?)"
+		value = prefix
+	default:
+		tsquery = "plainto_tsquery('simple', ?)"
+	}
+
+	fields := []string{t.field}
+
+	if t.field == "" {
+		fields = []string{"avatar_name", "avatar_author_name"}
+	}
+
+	conditions := make([]string, len(fields))
+	values := make([]interface{}, len(fields))
+
+	for i, field := range fields {
+		conditions[i] = fmt.Sprintf("to_tsvector('simple', %s) @@ %s", field, tsquery)
+		values[i] = value
+	}
+
+	return DatabaseConnection.Where(strings.Join(conditions, " OR "), values...)
+}
+
+// PrefixTsquery turns a prefix term into a to_tsquery expression. Only letters and digits are kept,
+// the simple parser splits words on everything else anyway and the rest would be read as operators.
+func PrefixTsquery(term string) string {
+	words := strings.FieldsFunc(term, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		words[i] += ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// SetupSearchIndexes creates the expression indexes the Postgres backend searches with and the
+// plain ones suggestion scores are counted with
+func SetupSearchIndexes(db *gorm.DB) {
+	for _, field := range []string{"avatar_name", "avatar_author_name"} {
+		err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_avatars_%s_tsv ON avatars USING GIN (to_tsvector('simple', %s))", field, field)).Error
+
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_avatars_%s ON avatars (%s)", field, field)).Error
+
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func GetSearchBackends() (SearchBackend, SearchBackend) {
+	if ServiceConfig.Search.Backend == SearchBackendPostgres {
+		return PostgresSearchBackend{}, RediSearchBackend{}
+	}
+
+	return RediSearchBackend{}, PostgresSearchBackend{}
+}
+
+// SearchWithFailover tries the configured backend first and falls back to the other one when it
+// errors, e.g. while Redis is down or the alias is being switched
+func SearchWithFailover(s *AvatarSearchQuery) ([]models.LimitedAvatar, int, error) {
+	primary, secondary := GetSearchBackends()
+
+	l, total, err := primary.Search(s)
+
+	if err == nil || ServiceConfig.Search.DisableFailover {
+		return l, total, err
+	}
+
+	fmt.Printf("Search backend %s failed, falling back to %s: %s\n", primary.Name(), secondary.Name(), err)
+
+	return secondary.Search(s)
+}
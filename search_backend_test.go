@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPrefixTsquery(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{"robo", "robo:*"},
+		{"Ävä", "Ävä:*"},
+		{`foo\`, "foo:*"},
+		{`foo'bar`, "foo:* & bar:*"},
+		{`it's`, "it:* & s:*"},
+		{"a&b|!c", "a:* & b:* & c:*"},
+		{"foo:*", "foo:*"},
+		{"(x)", "x:*"},
+		{`'\`, ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := PrefixTsquery(tt.term); got != tt.want {
+			t.Errorf("PrefixTsquery(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
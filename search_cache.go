@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultSearchCacheTtlSeconds = 30
+
+const (
+	searchCacheGenerationKey = "search_cache:generation"
+	searchCacheHitsKey       = "search_cache:hits"
+	searchCacheMissesKey     = "search_cache:misses"
+)
+
+func GetSearchCacheTtl() time.Duration {
+	seconds := ServiceConfig.Search.CacheTtlSeconds
+
+	if seconds <= 0 {
+		seconds = DefaultSearchCacheTtlSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// SearchCacheKey builds the cache key for a validated search. Bumping the generation moves every
+// search onto new keys, the old entries are left to expire.
+func SearchCacheKey(s *AvatarSearchQuery) (string, error) {
+	generation, err := RedisConnection.Get(ctx, searchCacheGenerationKey).Result()
+
+	if err == redis.Nil {
+		generation = "0"
+	} else if err != nil {
+		return "", err
+	}
+
+	text, _ := ParseSearchText(s.Query)
+	sort := s.Sort
+
+	if sort == "" {
+		sort = "relevance"
+	}
+
+	normalized := strings.Join([]string{
+		strings.ToLower(text),
+		strings.ToLower(s.AuthorId),
+		strconv.Itoa(s.Platforms),
+		s.Public,
+		sort,
+		strconv.FormatBool(SearchSortAscending(s)),
+		strconv.Itoa(s.Offset),
+		strconv.Itoa(s.Limit),
+	}, "\x00")
+
+	hash := sha256.Sum256([]byte(normalized))
+
+	return fmt.Sprintf("search_cache:%s:%s", generation, hex.EncodeToString(hash[:])), nil
+}
+
+// GetCachedSearch returns the encoded response body and total hit count stored under key
+func GetCachedSearch(key string) ([]byte, int, bool) {
+	res, err := RedisConnection.HGetAll(ctx, key).Result()
+
+	if err != nil || res["body"] == "" {
+		RedisConnection.Incr(ctx, searchCacheMissesKey)
+		return nil, 0, false
+	}
+
+	total, err := strconv.Atoi(res["total"])
+
+	if err != nil {
+		RedisConnection.Incr(ctx, searchCacheMissesKey)
+		return nil, 0, false
+	}
+
+	RedisConnection.Incr(ctx, searchCacheHitsKey)
+
+	return []byte(res["body"]), total, true
+}
+
+func SetCachedSearch(key string, body []byte, total int) {
+	pipe := RedisConnection.TxPipeline()
+	pipe.HSet(ctx, key, "body", body, "total", total)
+	pipe.Expire(ctx, key, GetSearchCacheTtl())
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Printf("Error caching search results: %s\n", err)
+	}
+}
+
+// InvalidateSearchCache drops every cached search after the index changes
+func InvalidateSearchCache() {
+	if err := RedisConnection.Incr(ctx, searchCacheGenerationKey).Err(); err != nil {
+		fmt.Printf("Error invalidating search cache: %s\n", err)
+	}
+}
+
+func GetSearchCacheStats() (*SearchCacheStats, error) {
+	res, err := RedisConnection.MGet(ctx, searchCacheHitsKey, searchCacheMissesKey, searchCacheGenerationKey).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]int64, len(res))
+
+	for i := range res {
+		if s, ok := res[i].(string); ok {
+			values[i], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+
+	stats := &SearchCacheStats{
+		Hits:       values[0],
+		Misses:     values[1],
+		Generation: values[2],
+		TtlSeconds: int(GetSearchCacheTtl().Seconds()),
+	}
+
+	if stats.Hits+stats.Misses > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	}
+
+	return stats, nil
+}
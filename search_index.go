@@ -0,0 +1,244 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// SearchIndexAlias is the name searches run against, it points at the live versioned index.
+// Before the first versioned rebuild it is still the legacy index itself, whose documents have no key prefix.
+const SearchIndexAlias = "avatarSearch"
+
+const (
+	currentSearchVersionKey  = "search_index:current"
+	buildingSearchVersionKey = "search_index:building"
+)
+
+func SearchIndexName(version string) string {
+	if version == "" {
+		return SearchIndexAlias
+	}
+
+	return SearchIndexAlias + "_v" + version
+}
+
+func SearchKeyPrefix(version string) string {
+	if version == "" {
+		return ""
+	}
+
+	return "avatar:v" + version + ":"
+}
+
+func SearchDocumentKey(version string, avatarIdSha256 string) string {
+	return SearchKeyPrefix(version) + avatarIdSha256
+}
+
+// GetSearchVersions returns the live index version and, while a rebuild runs, the one being built
+func GetSearchVersions() (string, string) {
+	current, err := RedisConnection.Get(ctx, currentSearchVersionKey).Result()
+
+	if err != nil && err != redis.Nil {
+		fmt.Printf("Error reading search index version: %s\n", err)
+	}
+
+	building, err := RedisConnection.Get(ctx, buildingSearchVersionKey).Result()
+
+	if err != nil && err != redis.Nil {
+		fmt.Printf("Error reading search index version: %s\n", err)
+	}
+
+	return current, building
+}
+
+// SetSearchDocument writes to the live index and to any index being rebuilt, so nothing added
+// mid-rebuild is lost when the alias switches over
+func SetSearchDocument(l *models.LimitedAvatar) error {
+	current, building := GetSearchVersions()
+
+	for _, version := range uniqueVersions(current, building) {
+		res, err := ReJsonClient.JSONSet(SearchDocumentKey(version, l.AvatarId), "$", l)
+
+		if err != nil {
+			return err
+		}
+
+		if res.(string) != "OK" {
+			fmt.Printf("Error adding avatar to search index: %s\n", l.AvatarId)
+		}
+	}
+
+	return nil
+}
+
+func DeleteSearchDocument(avatarIdSha256 string) error {
+	current, building := GetSearchVersions()
+
+	for _, version := range uniqueVersions(current, building) {
+		_, err := ReJsonClient.JSONDel(SearchDocumentKey(version, avatarIdSha256), "$")
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uniqueVersions(current string, building string) []string {
+	if building == "" || building == current {
+		return []string{current}
+	}
+
+	return []string{current, building}
+}
+
+var searchIndexSchema = []interface{}{
+	"SCHEMA",
+	"$.avatar_name", "AS", "avatar_name", "TEXT", "SORTABLE",
+	"$.avatar_author_name", "AS", "avatar_author_name", "TEXT",
+	"$.avatar_author_id", "AS", "avatar_author_id", "TAG",
+	"$.avatar_public", "AS", "avatar_public", "TAG",
+	"$.avatar_supported_platforms", "AS", "avatar_supported_platforms", "NUMERIC",
+	"$.avatar_created", "AS", "avatar_created", "NUMERIC", "SORTABLE",
+	"$.favorite_count", "AS", "favorite_count", "NUMERIC", "SORTABLE",
+}
+
+// CreateSearchIndex the filter and sort fields only exist on indexes built by a versioned rebuild,
+// so filtered searches need one rebuild after upgrading
+func CreateSearchIndex(version string) error {
+	args := []interface{}{"FT.CREATE", SearchIndexName(version), "ON", "JSON", "PREFIX", "1", SearchKeyPrefix(version)}
+
+	return RedisConnection.Do(ctx, append(args, searchIndexSchema...)...).Err()
+}
+
+// FilterLegacySearchIndex recreates the legacy index so it skips versioned documents. It has no
+// prefix and would otherwise pick up the documents of the first versioned rebuild and return
+// every avatar twice. The documents stay, searches only come back partial while Redis reindexes them.
+func FilterLegacySearchIndex() error {
+	if err := dropLegacySearchIndex(); err != nil {
+		return err
+	}
+
+	args := []interface{}{"FT.CREATE", SearchIndexAlias, "ON", "JSON", "FILTER", `!startswith(@__key, "avatar:v")`}
+
+	return RedisConnection.Do(ctx, append(args, searchIndexSchema...)...).Err()
+}
+
+func dropLegacySearchIndex() error {
+	err := RedisConnection.Do(ctx, "FT.DROPINDEX", SearchIndexAlias).Err()
+
+	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "unknown index") {
+		return err
+	}
+
+	return nil
+}
+
+// SwitchSearchIndex points the alias at the freshly built version and drops the previous index
+// and its documents in the background
+func SwitchSearchIndex(version string) error {
+	previous, _ := GetSearchVersions()
+
+	if previous == "" {
+		// the legacy index owns the alias name, so it has to go before the alias can be added.
+		// Searches fail for the moment in between, this only happens on the first versioned rebuild.
+		if err := dropLegacySearchIndex(); err != nil {
+			return err
+		}
+
+		RedisConnection.Unlink(ctx, SuggestDictionaryKeys(previous)...)
+	}
+
+	err := RedisConnection.Do(ctx, "FT.ALIASUPDATE", SearchIndexAlias, SearchIndexName(version)).Err()
+
+	if err != nil {
+		return err
+	}
+
+	pipe := RedisConnection.TxPipeline()
+	pipe.Set(ctx, currentSearchVersionKey, version, 0)
+	pipe.Del(ctx, buildingSearchVersionKey)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	InvalidateSearchCache()
+
+	if previous != "" {
+		go DropSearchIndex(previous)
+	} else {
+		go DropLegacySearchDocuments()
+	}
+
+	return nil
+}
+
+// DropSearchIndex removes an old index and unlinks its documents in batches rather than with
+// DD, which would block Redis until every document is gone
+func DropSearchIndex(version string) {
+	err := RedisConnection.Do(ctx, "FT.DROPINDEX", SearchIndexName(version)).Err()
+
+	if err != nil {
+		fmt.Printf("Error dropping old search index %s: %s\n", SearchIndexName(version), err)
+	}
+
+	RedisConnection.Unlink(ctx, SuggestDictionaryKeys(version)...)
+
+	var cursor uint64
+
+	for {
+		keys, next, err := RedisConnection.Scan(ctx, cursor, SearchKeyPrefix(version)+"*", 1000).Result()
+
+		if err != nil {
+			fmt.Printf("Error scanning old search documents %s: %s\n", SearchIndexName(version), err)
+			return
+		}
+
+		if len(keys) > 0 {
+			RedisConnection.Unlink(ctx, keys...)
+		}
+
+		cursor = next
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	fmt.Printf("Dropped old search index %s\n", SearchIndexName(version))
+}
+
+// DropLegacySearchDocuments unlinks the unprefixed documents of the legacy index. They are
+// looked up by avatar rather than scanned for, since without a prefix a scan can't tell them
+// apart from every other key.
+func DropLegacySearchDocuments() {
+	var a []models.Avatar
+
+	tx := DatabaseConnection.Select("avatar_id", "avatar_id_sha256").FindInBatches(&a, 1000, func(tx *gorm.DB, batch int) error {
+		keys := make([]string, 0, len(a))
+
+		for _, avatar := range a {
+			if avatar.AvatarIdSha256 != "" {
+				keys = append(keys, SearchDocumentKey("", avatar.AvatarIdSha256))
+			}
+		}
+
+		if len(keys) > 0 {
+			return RedisConnection.Unlink(ctx, keys...).Err()
+		}
+
+		return nil
+	})
+
+	if tx.Error != nil {
+		fmt.Printf("Error dropping legacy search documents: %s\n", tx.Error)
+		return
+	}
+
+	fmt.Println("Dropped legacy search documents")
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"github.com/RediSearch/redisearch-go/redisearch"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 500
+	// MaxSearchOffset RediSearch refuses to page past MAXSEARCHRESULTS, 10000 by default
+	MaxSearchOffset = 10000
+	// MaxPlatformValue bounds the values enumerated when filtering on platform bits
+	MaxPlatformValue    = 15
+	MaxSearchTextLength = 128
+	// MinPrefixLength matches RediSearch's default MINPREFIX, shorter prefixes are rejected by it
+	MinPrefixLength = 2
+)
+
+var ErrSearchTooLong = fiber.Map{"error": fmt.Sprintf("Search query must be at most %d characters.", MaxSearchTextLength)}
+var ErrSearchUnterminatedQuote = fiber.Map{"error": "Search query has an unterminated quote."}
+var ErrSearchEmptyField = fiber.Map{"error": "Search field prefixes must be followed by a term, e.g. author:name."}
+var ErrSearchWildcardPosition = fiber.Map{"error": "Wildcards are only allowed at the end of a word."}
+var ErrSearchPrefixTooShort = fiber.Map{"error": fmt.Sprintf("Prefix searches need at least %d characters before the wildcard.", MinPrefixLength)}
+
+var searchFields = map[string]string{
+	"name:":   "avatar_name",
+	"author:": "avatar_author_name",
+}
+
+type searchToken struct {
+	field  string
+	value  string
+	phrase bool
+	prefix bool
+}
+
+var ErrInvalidSearchSort = fiber.Map{"error": "Sort must be one of relevance, name, recent or popular."}
+var ErrInvalidSearchOrder = fiber.Map{"error": "Order must be asc or desc."}
+var ErrInvalidSearchPublic = fiber.Map{"error": "Public must be true or false."}
+var ErrInvalidSearchPlatforms = fiber.Map{"error": fmt.Sprintf("Platforms must be a bitmask between 0 and %d.", MaxPlatformValue)}
+
+func ClampSearchPaging(offset int, limit int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > MaxSearchOffset {
+		offset = MaxSearchOffset
+	}
+
+	if limit < 1 {
+		limit = DefaultSearchLimit
+	} else if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	return offset, limit
+}
+
+// ValidateSearchQuery checks the search parameters before any backend sees them and clamps
+// paging, returning the error body to send back when a parameter is invalid
+func ValidateSearchQuery(s *AvatarSearchQuery) fiber.Map {
+	if _, err := ParseSearchText(s.Query); err != nil {
+		return err
+	}
+
+	if s.Public != "" && s.Public != "true" && s.Public != "false" {
+		return ErrInvalidSearchPublic
+	}
+
+	if s.Platforms < 0 || s.Platforms > MaxPlatformValue {
+		return ErrInvalidSearchPlatforms
+	}
+
+	if s.Order != "" && s.Order != "asc" && s.Order != "desc" {
+		return ErrInvalidSearchOrder
+	}
+
+	if s.Sort != "" && s.Sort != "relevance" && s.Sort != "name" && s.Sort != "recent" && s.Sort != "popular" {
+		return ErrInvalidSearchSort
+	}
+
+	s.Offset, s.Limit = ClampSearchPaging(s.Offset, s.Limit)
+
+	return nil
+}
+
+// SearchSortAscending name sorts default to A-Z, recency and popularity sorts to newest and most
+// favorited first
+func SearchSortAscending(s *AvatarSearchQuery) bool {
+	if s.Order == "" {
+		return s.Sort == "name"
+	}
+
+	return s.Order == "asc"
+}
+
+// BuildSearchQuery turns validated search parameters into a RediSearch query
+func BuildSearchQuery(s *AvatarSearchQuery) *redisearch.Query {
+	parts := make([]string, 0, 4)
+
+	if text, _ := ParseSearchText(s.Query); text != "" {
+		parts = append(parts, text)
+	}
+
+	if s.AuthorId != "" {
+		parts = append(parts, fmt.Sprintf("@avatar_author_id:{%s}", EscapeSearchValue(s.AuthorId)))
+	}
+
+	if s.Public != "" {
+		parts = append(parts, fmt.Sprintf("@avatar_public:{%s}", s.Public))
+	}
+
+	if s.Platforms > 0 {
+		parts = append(parts, PlatformFilter(s.Platforms))
+	}
+
+	raw := strings.Join(parts, " ")
+
+	if raw == "" {
+		raw = "*"
+	}
+
+	q := redisearch.NewQuery(raw).Limit(s.Offset, s.Limit)
+
+	switch s.Sort {
+	case "name":
+		q.SetSortBy("avatar_name", SearchSortAscending(s))
+	case "recent":
+		q.SetSortBy("avatar_created", SearchSortAscending(s))
+	case "popular":
+		q.SetSortBy("favorite_count", SearchSortAscending(s))
+	}
+
+	return q
+}
+
+// PlatformFilter matches avatars supporting every platform bit in mask. RediSearch has no bitwise
+// operators, so it lists each value that contains the mask.
+func PlatformFilter(mask int) string {
+	values := make([]string, 0)
+
+	for v := mask; v <= MaxPlatformValue; v++ {
+		if v&mask == mask {
+			values = append(values, fmt.Sprintf("@avatar_supported_platforms:[%d %d]", v, v))
+		}
+	}
+
+	return "(" + strings.Join(values, " | ") + ")"
+}
+
+// EscapeSearchValue backslash escapes everything but letters and digits, which RediSearch would
+// otherwise treat as separators or query syntax
+func EscapeSearchValue(value string) string {
+	var b strings.Builder
+
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// ParseSearchText turns user input into a RediSearch query with every special character escaped.
+// Supported syntax, with terms combined using AND:
+//
+//	word          matches word in the avatar or author name
+//	"two words"   matches the exact phrase
+//	wor*          prefix search, only at the end of a word and after at least two characters
+//	name:word     only matches the avatar name, also works with phrases and prefixes
+//	author:word   only matches the author name
+func ParseSearchText(input string) (string, fiber.Map) {
+	if len([]rune(input)) > MaxSearchTextLength {
+		return "", ErrSearchTooLong
+	}
+
+	tokens, err := tokenizeSearchText(input)
+
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(tokens))
+
+	for _, t := range tokens {
+		var term string
+
+		if t.phrase {
+			words := strings.Fields(t.value)
+
+			for i := range words {
+				words[i] = EscapeSearchValue(words[i])
+			}
+
+			term = `"` + strings.Join(words, " ") + `"`
+		} else {
+			term = EscapeSearchValue(t.value)
+
+			if t.prefix {
+				term += "*"
+			}
+		}
+
+		if t.field != "" {
+			term = fmt.Sprintf("@%s:(%s)", t.field, term)
+		}
+
+		parts = append(parts, term)
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+func tokenizeSearchText(input string) ([]searchToken, fiber.Map) {
+	runes := []rune(input)
+	tokens := make([]searchToken, 0)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var t searchToken
+
+		for prefix, field := range searchFields {
+			if strings.HasPrefix(strings.ToLower(string(runes[i:])), prefix) {
+				t.field = field
+				i += len([]rune(prefix))
+				break
+			}
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+
+			if end >= len(runes) {
+				return nil, ErrSearchUnterminatedQuote
+			}
+
+			t.phrase = true
+			t.value = string(runes[i+1 : end])
+			i = end + 1
+
+			if strings.TrimSpace(t.value) == "" {
+				if t.field != "" {
+					return nil, ErrSearchEmptyField
+				}
+
+				continue
+			}
+
+			tokens = append(tokens, t)
+			continue
+		}
+
+		start := i
+
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+			i++
+		}
+
+		word := string(runes[start:i])
+
+		if word == "" {
+			if t.field != "" {
+				return nil, ErrSearchEmptyField
+			}
+
+			continue
+		}
+
+		if strings.HasSuffix(word, "*") {
+			t.prefix = true
+			word = strings.TrimSuffix(word, "*")
+
+			if len([]rune(word)) < MinPrefixLength {
+				return nil, ErrSearchPrefixTooShort
+			}
+		}
+
+		if strings.Contains(word, "*") {
+			return nil, ErrSearchWildcardPosition
+		}
+
+		t.value = word
+		tokens = append(tokens, t)
+	}
+
+	return tokens, nil
+}
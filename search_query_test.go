@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscapeSearchValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"avatar", "avatar"},
+		{"Ävätar2", "Ävätar2"},
+		{"foo-bar", `foo\-bar`},
+		{"a.b", `a\.b`},
+		{"@name:x", `\@name\:x`},
+		{`"(|)~{}[]`, `\"\(\|\)\~\{\}\[\]`},
+		{`back\slash`, `back\\slash`},
+		{"a b", `a\ b`},
+		{"*", `\*`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeSearchValue(tt.value); got != tt.want {
+			t.Errorf("EscapeSearchValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchText(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   map[string]interface{}
+	}{
+		{"", "", nil},
+		{"robot", "robot", nil},
+		{"robot cat", "robot cat", nil},
+		{"  robot  ", "robot", nil},
+		{"robo*", "robo*", nil},
+		{`"blue robot"`, `"blue robot"`, nil},
+		{`"blue-robot cat"`, `"blue\-robot cat"`, nil},
+		{`""`, "", nil},
+		{"name:robot", "@avatar_name:(robot)", nil},
+		{"NAME:robot", "@avatar_name:(robot)", nil},
+		{"author:emm", "@avatar_author_name:(emm)", nil},
+		{"author:em*", "@avatar_author_name:(em*)", nil},
+		{`name:"blue robot"`, `@avatar_name:("blue robot")`, nil},
+		{"@avatar_name:x", `\@avatar\_name\:x`, nil},
+		{"a|b -c", `a\|b \-c`, nil},
+		{"(x) {y}", `\(x\) \{y\}`, nil},
+		{`"unterminated`, "", ErrSearchUnterminatedQuote},
+		{`robot "blue`, "", ErrSearchUnterminatedQuote},
+		{"name:", "", ErrSearchEmptyField},
+		{`author:""`, "", ErrSearchEmptyField},
+		{"r*", "", ErrSearchPrefixTooShort},
+		{"*", "", ErrSearchPrefixTooShort},
+		{"ro*bot", "", ErrSearchWildcardPosition},
+		{"ro**", "", ErrSearchWildcardPosition},
+		{string(make([]rune, MaxSearchTextLength+1)), "", ErrSearchTooLong},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSearchText(tt.input)
+
+		if tt.err != nil {
+			if !reflect.DeepEqual(map[string]interface{}(err), tt.err) {
+				t.Errorf("ParseSearchText(%q) error = %v, want %v", tt.input, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSearchText(%q) unexpected error %v", tt.input, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseSearchText(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformFilter(t *testing.T) {
+	tests := []struct {
+		mask int
+		want string
+	}{
+		{
+			mask: 0,
+			want: "(@avatar_supported_platforms:[0 0] | @avatar_supported_platforms:[1 1] | " +
+				"@avatar_supported_platforms:[2 2] | @avatar_supported_platforms:[3 3] | " +
+				"@avatar_supported_platforms:[4 4] | @avatar_supported_platforms:[5 5] | " +
+				"@avatar_supported_platforms:[6 6] | @avatar_supported_platforms:[7 7] | " +
+				"@avatar_supported_platforms:[8 8] | @avatar_supported_platforms:[9 9] | " +
+				"@avatar_supported_platforms:[10 10] | @avatar_supported_platforms:[11 11] | " +
+				"@avatar_supported_platforms:[12 12] | @avatar_supported_platforms:[13 13] | " +
+				"@avatar_supported_platforms:[14 14] | @avatar_supported_platforms:[15 15])",
+		},
+		{
+			mask: 1,
+			want: "(@avatar_supported_platforms:[1 1] | @avatar_supported_platforms:[3 3] | " +
+				"@avatar_supported_platforms:[5 5] | @avatar_supported_platforms:[7 7] | " +
+				"@avatar_supported_platforms:[9 9] | @avatar_supported_platforms:[11 11] | " +
+				"@avatar_supported_platforms:[13 13] | @avatar_supported_platforms:[15 15])",
+		},
+		{
+			mask: 6,
+			want: "(@avatar_supported_platforms:[6 6] | @avatar_supported_platforms:[7 7] | " +
+				"@avatar_supported_platforms:[14 14] | @avatar_supported_platforms:[15 15])",
+		},
+		{
+			mask: 15,
+			want: "(@avatar_supported_platforms:[15 15])",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := PlatformFilter(tt.mask); got != tt.want {
+			t.Errorf("PlatformFilter(%d) = %q, want %q", tt.mask, got, tt.want)
+		}
+	}
+}
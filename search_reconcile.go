@@ -0,0 +1,243 @@
+package main
+
+import (
+	"emmApi/models"
+	"errors"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// maxReportIds caps how many avatar ids of each kind a reconcile report lists, the counts stay exact
+const maxReportIds = 100
+
+var ErrReconcileLegacyIndex = errors.New("the legacy search index can't be checked, rebuild it first")
+
+func InitReconcileService() {
+	hours := ServiceConfig.Search.ReconcileIntervalHours
+
+	if hours <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(hours) * time.Hour)
+	go func() {
+		for range ticker.C {
+			_, err := EnqueueUniqueJob(JobReconcileSearch, SearchReconcileRequest{Repair: ServiceConfig.Search.ReconcileRepair}, "scheduler")
+
+			if err != nil && err != ErrJobAlreadyActive {
+				fmt.Printf("Error scheduling search index check: %s\n", err)
+			}
+		}
+	}()
+}
+
+// ReconcileSearchIndexJob compares every avatar row with its document in the live index, then
+// scans the index for documents without a row. With repair set it rewrites missing and stale
+// documents and removes the ones that shouldn't exist.
+func ReconcileSearchIndexJob(j *JobContext) error {
+	var r SearchReconcileRequest
+	var a []models.Avatar
+	var b []models.BlacklistedAuthor
+	var total, done int64
+
+	if err := j.DecodePayload(&r); err != nil {
+		return err
+	}
+
+	version, _ := GetSearchVersions()
+
+	if version == "" {
+		return ErrReconcileLegacyIndex
+	}
+
+	report := SearchReconcileReport{
+		Version:       version,
+		Repaired:      r.Repair,
+		MissingIds:    make([]string, 0),
+		StaleIds:      make([]string, 0),
+		UnexpectedIds: make([]string, 0),
+	}
+
+	if err := DatabaseConnection.Find(&b).Error; err != nil {
+		return err
+	}
+
+	blacklisted := make(map[string]bool, len(b))
+
+	for _, author := range b {
+		blacklisted[author.UserId] = true
+	}
+
+	query := DatabaseConnection.Model(&models.Avatar{})
+
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+
+	tx := query.FindInBatches(&a, 1000, func(tx *gorm.DB, batch int) error {
+		pipe := RedisConnection.Pipeline()
+		cmds := make([]*redis.Cmd, len(a))
+
+		for i := range a {
+			cmds[i] = pipe.Do(ctx, "JSON.GET", SearchDocumentKey(version, a[i].AvatarIdSha256), "$")
+		}
+
+		// missing documents come back as redis.Nil, which is checked per command below
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
+
+		for i := range a {
+			if err := reconcileAvatar(&report, &a[i], cmds[i], blacklisted, r.Repair); err != nil {
+				return err
+			}
+		}
+
+		report.Checked += int64(len(a))
+		done += int64(len(a))
+		return j.Progress(done, total)
+	})
+
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := reconcileOrphans(j, &report, version, r.Repair); err != nil {
+		return err
+	}
+
+	if r.Repair {
+		InvalidateSearchCache()
+	}
+
+	return j.SetResult(report)
+}
+
+func reconcileAvatar(report *SearchReconcileReport, a *models.Avatar, cmd *redis.Cmd, blacklisted map[string]bool, repair bool) error {
+	expected := a.AvatarPublic && !a.IsShadowed && !blacklisted[a.AvatarAuthorId]
+	raw, err := cmd.Text()
+
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	exists := err == nil
+
+	switch {
+	case expected && !exists:
+		report.Missing++
+		report.MissingIds = appendReportId(report.MissingIds, a.AvatarIdSha256)
+	case expected && exists:
+		var doc models.LimitedAvatar
+
+		// the $ path returns the document wrapped in an array
+		raw = strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")
+
+		if sonic.Unmarshal([]byte(raw), &doc) == nil && IsSearchDocumentCurrent(&doc, a.GetLimitedAvatar()) {
+			return nil
+		}
+
+		report.Stale++
+		report.StaleIds = appendReportId(report.StaleIds, a.AvatarIdSha256)
+	case !expected && exists:
+		report.Unexpected++
+		report.UnexpectedIds = appendReportId(report.UnexpectedIds, a.AvatarIdSha256)
+
+		if repair {
+			return DeleteSearchDocument(a.AvatarIdSha256)
+		}
+
+		return nil
+	default:
+		return nil
+	}
+
+	if repair {
+		return SetSearchDocument(a.GetLimitedAvatar())
+	}
+
+	return nil
+}
+
+// reconcileOrphans finds documents whose avatar row no longer exists
+func reconcileOrphans(j *JobContext, report *SearchReconcileReport, version string, repair bool) error {
+	var cursor uint64
+
+	prefix := SearchKeyPrefix(version)
+
+	for {
+		keys, next, err := RedisConnection.Scan(ctx, cursor, prefix+"*", 1000).Result()
+
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			var known []string
+
+			ids := make([]string, len(keys))
+
+			for i, key := range keys {
+				ids[i] = strings.TrimPrefix(key, prefix)
+			}
+
+			tx := DatabaseConnection.Model(&models.Avatar{}).Where("avatar_id_sha256 IN ?", ids).Pluck("avatar_id_sha256", &known)
+
+			if tx.Error != nil {
+				return tx.Error
+			}
+
+			exists := make(map[string]bool, len(known))
+
+			for _, id := range known {
+				exists[id] = true
+			}
+
+			for _, id := range ids {
+				if exists[id] {
+					continue
+				}
+
+				report.Unexpected++
+				report.UnexpectedIds = appendReportId(report.UnexpectedIds, id)
+
+				if repair {
+					if err := DeleteSearchDocument(id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if j.Context.Err() != nil {
+			return ErrJobCancelled
+		}
+
+		cursor = next
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func appendReportId(ids []string, id string) []string {
+	if len(ids) >= maxReportIds {
+		return ids
+	}
+
+	return append(ids, id)
+}
+
+// IsSearchDocumentCurrent compares the fields the index owns. Favorite counts are synced in the
+// background and can lag behind without the document being stale.
+func IsSearchDocumentCurrent(doc *models.LimitedAvatar, expected *models.LimitedAvatar) bool {
+	d := *doc
+	d.FavoriteCount = expected.FavoriteCount
+
+	return d == *expected
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"emmApi/models"
+	"testing"
+)
+
+func TestIsSearchDocumentCurrent(t *testing.T) {
+	expected := models.LimitedAvatar{AvatarId: "sha", AvatarName: "Robot", FavoriteCount: 5}
+
+	tests := []struct {
+		name string
+		doc  models.LimitedAvatar
+		want bool
+	}{
+		{"identical", expected, true},
+		{"favorite count lagging", models.LimitedAvatar{AvatarId: "sha", AvatarName: "Robot", FavoriteCount: 3}, true},
+		{"renamed", models.LimitedAvatar{AvatarId: "sha", AvatarName: "Cat", FavoriteCount: 5}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSearchDocumentCurrent(&tt.doc, &expected); got != tt.want {
+				t.Errorf("IsSearchDocumentCurrent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
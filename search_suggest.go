@@ -0,0 +1,218 @@
+package main
+
+import (
+	"emmApi/models"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+)
+
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 20
+)
+
+// suggestFields are the avatar fields kept in a suggestion dictionary each
+var suggestFields = []string{"avatar_name", "avatar_author_name"}
+
+// SuggestDictionaryKey suggestion dictionaries are versioned together with the search index so a
+// rebuild can fill fresh ones and switch over in the same step
+func SuggestDictionaryKey(version string, field string) string {
+	if version == "" {
+		return "suggest:" + field
+	}
+
+	return "suggest:v" + version + ":" + field
+}
+
+func SuggestDictionaryKeys(version string) []string {
+	keys := make([]string, len(suggestFields))
+
+	for i, field := range suggestFields {
+		keys[i] = SuggestDictionaryKey(version, field)
+	}
+
+	return keys
+}
+
+// AddSuggestions adds an avatar's names to the live dictionaries and to any being rebuilt.
+// Scores count how many searchable avatars share a name, so common names rank first. They are
+// set rather than incremented, so reindexing the same avatar again doesn't inflate them.
+func AddSuggestions(a *models.Avatar) error {
+	current, building := GetSearchVersions()
+
+	for _, version := range uniqueVersions(current, building) {
+		if err := AddSuggestionsTo(version, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func AddSuggestionsTo(version string, a *models.Avatar) error {
+	// private avatars aren't searchable, their names shouldn't leak through suggestions either
+	if !a.AvatarPublic || a.IsShadowed {
+		return nil
+	}
+
+	for _, field := range suggestFields {
+		var count int64
+
+		value := SuggestionValue(a, field)
+
+		if value == "" {
+			continue
+		}
+
+		if err := SearchableAvatars().Where(field+" = ?", value).Count(&count).Error; err != nil {
+			return err
+		}
+
+		// the avatar may not be saved yet, it still counts itself
+		if count < 1 {
+			count = 1
+		}
+
+		if err := SetSuggestion(version, field, value, count, a.AvatarAuthorId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FillSuggestionsTo fills the dictionaries of a rebuild, counting every name in one query per
+// field rather than one per avatar
+func FillSuggestionsTo(version string) error {
+	for _, field := range suggestFields {
+		if err := fillSuggestionField(version, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fillSuggestionField(version string, field string) error {
+	rows, err := SearchableAvatars().
+		Select(field + " AS value, COUNT(*) AS count, MAX(avatar_author_id) AS author_id").
+		Where(field + " <> ''").
+		Group(field).
+		Rows()
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var value, authorId string
+		var count int64
+
+		if err := rows.Scan(&value, &count, &authorId); err != nil {
+			return err
+		}
+
+		if err := SetSuggestion(version, field, value, count, authorId); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func SuggestionValue(a *models.Avatar, field string) string {
+	if field == "avatar_author_name" {
+		return a.AvatarAuthorName
+	}
+
+	return a.AvatarName
+}
+
+// SetSuggestion replaces the score of a suggestion, author names carry the author id as payload
+func SetSuggestion(version string, field string, value string, score int64, authorId string) error {
+	args := []interface{}{"FT.SUGADD", SuggestDictionaryKey(version, field), value, score}
+
+	if field == "avatar_author_name" {
+		args = append(args, "PAYLOAD", authorId)
+	}
+
+	return RedisConnection.Do(ctx, args...).Err()
+}
+
+// RemoveSuggestions takes an avatar's names out of the dictionaries once no other searchable
+// avatar uses them, otherwise it sets their score to the avatars that are left
+func RemoveSuggestions(a *models.Avatar) error {
+	current, building := GetSearchVersions()
+
+	for _, field := range suggestFields {
+		var others int64
+
+		value := SuggestionValue(a, field)
+
+		if value == "" {
+			continue
+		}
+
+		tx := SearchableAvatars().Where(field+" = ? AND avatar_id <> ?", value, a.AvatarId).Count(&others)
+
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		for _, version := range uniqueVersions(current, building) {
+			var err error
+			key := SuggestDictionaryKey(version, field)
+
+			if others == 0 {
+				err = RedisConnection.Do(ctx, "FT.SUGDEL", key, value).Err()
+			} else {
+				err = SetSuggestion(version, field, value, others, a.AvatarAuthorId)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetSuggestions returns the best completions for prefix from the live dictionary of field
+func GetSuggestions(field string, prefix string, limit int) ([]SearchSuggestion, error) {
+	current, _ := GetSearchVersions()
+
+	res, err := RedisConnection.Do(ctx, "FT.SUGGET", SuggestDictionaryKey(current, field), prefix,
+		"MAX", limit, "WITHSCORES", "WITHPAYLOADS").Slice()
+
+	suggestions := make([]SearchSuggestion, 0)
+
+	if err == redis.Nil {
+		return suggestions, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+2 < len(res); i += 3 {
+		text, _ := res[i].(string)
+		score, _ := res[i+1].(string)
+		payload, _ := res[i+2].(string)
+
+		s := SearchSuggestion{Text: text}
+
+		if s.Score, err = strconv.ParseFloat(score, 64); err != nil {
+			return nil, fmt.Errorf("invalid suggestion score %q", score)
+		}
+
+		if field == "avatar_author_name" {
+			s.AuthorId = payload
+		}
+
+		suggestions = append(suggestions, s)
+	}
+
+	return suggestions, nil
+}